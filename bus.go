@@ -0,0 +1,131 @@
+package echocore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// EventHandler handles a raw event payload published on a topic.
+type EventHandler func(ctx context.Context, topic string, payload []byte) error
+
+// Bus is an event bus on Redis pub/sub, so events reach subscribers on all
+// replicas. Delivery is at most once: events published while a replica is
+// not subscribed (e.g. during reconnects) are not delivered to it.
+type Bus struct {
+	core     *Core
+	prefix   string
+	mu       sync.Mutex
+	handlers map[string][]EventHandler
+	ps       *redis.PubSub
+}
+
+// InitBus creates Core.Bus and registers a worker receiving events. The
+// worker resubscribes all topics after connection loss. Requires InitRedis.
+func (c *Core) InitBus() InitHandler {
+	return func() error {
+		logInit("Bus")
+
+		if c.Redis == nil {
+			return errors.New("bus requires redis")
+		}
+
+		c.Bus = &Bus{
+			core:     c,
+			prefix:   c.Config.Bus.ChannelPrefix,
+			handlers: map[string][]EventHandler{},
+		}
+		c.Worker("Bus", c.Bus.run)
+		return nil
+	}
+}
+
+// Publish publishes the JSON encoded event to all subscribers of topic.
+func (b *Bus) Publish(ctx context.Context, topic string, event any) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.core.Redis.Publish(ctx, b.prefix+topic, bs).Err()
+}
+
+// Handle registers a raw handler for topic.
+func (b *Bus) Handle(topic string, h EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, subscribed := b.handlers[topic]
+	b.handlers[topic] = append(b.handlers[topic], h)
+	if b.ps != nil && !subscribed {
+		if err := b.ps.Subscribe(b.core.Context(), b.prefix+topic); err != nil {
+			logrus.Warnf("[Bus] [%s] %s", topic, err.Error())
+		}
+	}
+}
+
+// Subscribe registers a typed handler for topic; events are decoded from JSON
+// into T before fn is called. Handlers run one after another on the bus
+// worker, so they should return quickly.
+func Subscribe[T any](b *Bus, topic string, fn func(ctx context.Context, event T) error) {
+	b.Handle(topic, func(ctx context.Context, _ string, payload []byte) error {
+		var event T
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		return fn(ctx, event)
+	})
+}
+
+func (b *Bus) run(ctx context.Context) error {
+
+	b.mu.Lock()
+	channels := make([]string, 0, len(b.handlers))
+	for topic := range b.handlers {
+		channels = append(channels, b.prefix+topic)
+	}
+	ps := b.core.Redis.Subscribe(ctx, channels...)
+	b.ps = ps
+	b.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() { _ = ps.Close() })
+	defer func() {
+		stop()
+		b.mu.Lock()
+		b.ps = nil
+		b.mu.Unlock()
+		_ = ps.Close()
+	}()
+
+	for {
+		msg, err := ps.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		b.dispatch(ctx, strings.TrimPrefix(msg.Channel, b.prefix), []byte(msg.Payload))
+	}
+}
+
+func (b *Bus) dispatch(ctx context.Context, topic string, payload []byte) {
+	b.mu.Lock()
+	handlers := b.handlers[topic]
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		if err := callEventHandler(ctx, h, topic, payload); err != nil {
+			logrus.Errorf("[Bus] [%s] %s", topic, err.Error())
+		}
+	}
+}
+
+func callEventHandler(ctx context.Context, h EventHandler, topic string, payload []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, topic, payload)
+}
@@ -0,0 +1,331 @@
+package echocore
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const cacheOriginLen = 8
+
+var (
+	// ErrCacheMiss is returned by Cache.Get if a key is not cached.
+	ErrCacheMiss = errors.New("cache miss")
+	// ErrNotFound is returned by loaders to signal a missing value. It is
+	// cached for CacheOptions.NegativeTTL.
+	ErrNotFound = errors.New("not found")
+)
+
+type CacheOptions struct {
+	// TTL of values in Redis.
+	TTL time.Duration
+	// NegativeTTL of ErrNotFound results. Disabled if 0.
+	NegativeTTL time.Duration
+	// LocalSize is the number of entries kept in the in-memory LRU in front
+	// of Redis. Disabled if 0.
+	LocalSize int
+	// LocalTTL bounds the lifetime of in-memory entries.
+	LocalTTL time.Duration
+}
+
+// Cache is a typed get-or-load cache on Core.Redis with an optional
+// in-memory LRU tier. Values are stored as JSON. Changes are broadcast over
+// Redis pub/sub, so in-memory tiers of all replicas drop stale entries.
+type Cache[T any] struct {
+	core    *Core
+	name    string
+	opts    CacheOptions
+	origin  string
+	local   *lru
+	mu      sync.Mutex
+	flights map[string]*cacheFlight[T]
+}
+
+type cacheEntry[T any] struct {
+	Value   T    `json:"v"`
+	Missing bool `json:"m,omitempty"`
+}
+
+type cacheFlight[T any] struct {
+	wg    sync.WaitGroup
+	value T
+	err   error
+}
+
+type cacheInvalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// NewCache creates a named cache. With a local tier, a worker listening for
+// invalidations of other replicas is registered on core.
+func NewCache[T any](core *Core, name string, opts CacheOptions) *Cache[T] {
+	bs := make([]byte, cacheOriginLen)
+	_, _ = rand.Read(bs)
+
+	c := &Cache[T]{
+		core:    core,
+		name:    name,
+		opts:    opts,
+		origin:  hex.EncodeToString(bs),
+		flights: map[string]*cacheFlight[T]{},
+	}
+	if opts.LocalSize > 0 {
+		c.local = newLRU(opts.LocalSize)
+		core.Worker("Cache:"+name, c.listen)
+	}
+	return c
+}
+
+// Get returns the cached value for key, ErrNotFound for a cached negative
+// result or ErrCacheMiss.
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	var zero T
+
+	if c.local != nil {
+		if v, ok := c.local.get(key); ok {
+			e := v.(cacheEntry[T])
+			if e.Missing {
+				return zero, ErrNotFound
+			}
+			return e.Value, nil
+		}
+	}
+
+	bs, err := c.core.Redis.Get(ctx, c.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return zero, ErrCacheMiss
+	}
+	if err != nil {
+		return zero, err
+	}
+
+	var e cacheEntry[T]
+	if err = json.Unmarshal(bs, &e); err != nil {
+		return zero, err
+	}
+	c.setLocal(key, e)
+	if e.Missing {
+		return zero, ErrNotFound
+	}
+	return e.Value, nil
+}
+
+// GetOrLoad returns the cached value or calls load and caches its result.
+// Concurrent loads of the same key within this instance are coalesced.
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) (T, error)) (T, error) {
+
+	v, err := c.Get(ctx, key)
+	if !errors.Is(err, ErrCacheMiss) {
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			logrus.Warnf("[Cache] [%s] %s", c.name, err.Error())
+			return load(ctx)
+		}
+		return v, err
+	}
+
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		f.wg.Wait()
+		return f.value, f.err
+	}
+	f := &cacheFlight[T]{}
+	f.wg.Add(1)
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+		f.wg.Done()
+	}()
+
+	f.value, f.err = load(ctx)
+	switch {
+	case f.err == nil:
+		err = c.store(ctx, key, cacheEntry[T]{Value: f.value}, c.opts.TTL)
+	case errors.Is(f.err, ErrNotFound) && c.opts.NegativeTTL > 0:
+		err = c.store(ctx, key, cacheEntry[T]{Missing: true}, c.opts.NegativeTTL)
+	}
+	if err != nil {
+		logrus.Warnf("[Cache] [%s] %s", c.name, err.Error())
+	}
+	return f.value, f.err
+}
+
+// Set stores value for key.
+func (c *Cache[T]) Set(ctx context.Context, key string, value T) error {
+	return c.store(ctx, key, cacheEntry[T]{Value: value}, c.opts.TTL)
+}
+
+// Delete removes keys from Redis and from the in-memory tier of all replicas.
+func (c *Cache[T]) Delete(ctx context.Context, keys ...string) error {
+	rkeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		rkeys = append(rkeys, c.key(k))
+	}
+	if err := c.core.Redis.Del(ctx, rkeys...).Err(); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, keys...)
+}
+
+func (c *Cache[T]) store(ctx context.Context, key string, e cacheEntry[T], ttl time.Duration) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = c.core.Redis.Set(ctx, c.key(key), bs, ttl).Err(); err != nil {
+		return err
+	}
+	if err = c.invalidate(ctx, key); err != nil {
+		return err
+	}
+	c.setLocal(key, e)
+	return nil
+}
+
+func (c *Cache[T]) setLocal(key string, e cacheEntry[T]) {
+	if c.local == nil {
+		return
+	}
+	ttl := c.opts.LocalTTL
+	if e.Missing && (ttl == 0 || c.opts.NegativeTTL < ttl) {
+		ttl = c.opts.NegativeTTL
+	} else if !e.Missing && c.opts.TTL > 0 && (ttl == 0 || c.opts.TTL < ttl) {
+		ttl = c.opts.TTL
+	}
+	c.local.set(key, e, ttl)
+}
+
+func (c *Cache[T]) invalidate(ctx context.Context, keys ...string) error {
+	if c.local == nil {
+		return nil
+	}
+	c.local.del(keys...)
+	bs, err := json.Marshal(&cacheInvalidation{Origin: c.origin, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return c.core.Redis.Publish(ctx, c.channel(), bs).Err()
+}
+
+func (c *Cache[T]) listen(ctx context.Context) error {
+	ps := c.core.Redis.Subscribe(ctx, c.channel())
+	stop := context.AfterFunc(ctx, func() { _ = ps.Close() })
+	defer func() {
+		stop()
+		_ = ps.Close()
+	}()
+
+	if _, err := ps.Receive(ctx); err != nil {
+		return err
+	}
+	// invalidations might have been missed while not subscribed
+	c.local.purge()
+
+	for {
+		msg, err := ps.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		var inv cacheInvalidation
+		if err = json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			logrus.Warnf("[Cache] [%s] %s", c.name, err.Error())
+			continue
+		}
+		if inv.Origin != c.origin {
+			c.local.del(inv.Keys...)
+		}
+	}
+}
+
+func (c *Cache[T]) key(key string) string {
+	return c.core.Config.Cache.KeyPrefix + c.name + ":" + key
+}
+
+func (c *Cache[T]) channel() string {
+	return c.core.Config.Cache.KeyPrefix + c.name + ":invalidate"
+}
+
+// lru is a size bounded in-memory cache with per entry expiry.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (l *lru) get(key string) (any, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*lruItem)
+	if !item.expires.IsZero() && time.Now().After(item.expires) {
+		l.order.Remove(el)
+		delete(l.items, key)
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return item.value, true
+}
+
+func (l *lru) set(key string, value any, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	item := &lruItem{key: key, value: value}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	if el, ok := l.items[key]; ok {
+		el.Value = item
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.order.PushFront(item)
+	for l.order.Len() > l.size {
+		el := l.order.Back()
+		l.order.Remove(el)
+		delete(l.items, el.Value.(*lruItem).key)
+	}
+}
+
+func (l *lru) del(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := l.items[key]; ok {
+			l.order.Remove(el)
+			delete(l.items, key)
+		}
+	}
+}
+
+func (l *lru) purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.order.Init()
+	l.items = map[string]*list.Element{}
+}
@@ -0,0 +1,156 @@
+package echocore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	impl "github.com/go-playground/validator/v10"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const (
+	cmdCheck = "check"
+	cmdDump  = "dump"
+	cmdGen   = "gen"
+)
+
+// ConfigError lists all problems found by Config.Check.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Check validates the config and tries to load all configured TLS material.
+// Unlike NewCore it does not stop at the first problem; a *ConfigError
+// holding every problem found is returned.
+func (cfg *Config) Check() error {
+	return checkConfig(cfg)
+}
+
+// ConfigCommand runs a config sub command ("check", "dump" or "gen") against the
+// current environment, writes the result to w and returns the exit code.
+func ConfigCommand(args []string, w io.Writer) int {
+	return ConfigCommandWith[Config](args, w)
+}
+
+// ConfigCommandWith is the counterpart of ConfigCommand for an application
+// config struct T embedding Config, see NewCoreWith.
+func ConfigCommandWith[T any, P interface {
+	*T
+	Configurer
+}](args []string, w io.Writer) int {
+	cfg := P(new(T))
+	if err := loadConfig(cfg); err != nil {
+		_, _ = fmt.Fprintln(w, err.Error())
+		return 1
+	}
+	return configCommand(cfg, args, w)
+}
+
+func configCommand(cfg Configurer, args []string, w io.Writer) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprintf(w, "usage: config <%s|%s|%s>\n", cmdCheck, cmdDump, cmdGen)
+		return 2
+	}
+	switch args[0] {
+	case cmdCheck:
+		if err := checkConfig(cfg); err != nil {
+			_, _ = fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		_, _ = fmt.Fprintln(w, "config ok")
+		return 0
+	case cmdDump:
+		bs, err := dumpConfig(cfg)
+		if err != nil {
+			_, _ = fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		_, _ = fmt.Fprintln(w, string(bs))
+		return 0
+	case cmdGen:
+		if err := genCommand(cfg, args[1:], w); err != nil {
+			_, _ = fmt.Fprintln(w, err.Error())
+			return 1
+		}
+		return 0
+	default:
+		_, _ = fmt.Fprintf(w, "unknown config command: %s\n", args[0])
+		return 2
+	}
+}
+
+func checkConfig(cfg Configurer) error {
+
+	var problems []string
+
+	base := cfg.Base()
+
+	if err := NewValidator().Validate(cfg); err != nil {
+		var verrs impl.ValidationErrors
+		if !errors.As(err, &verrs) {
+			return err
+		}
+		for _, fe := range verrs {
+			problems = append(problems, describeFieldError(reflect.TypeOf(cfg), fe))
+		}
+	}
+
+	problems = append(problems, checkTLS("DB", base.DB.TLS.Crt, base.DB.TLS.Key, base.DB.TLS.RootCAs, base.DB.TLS.ClientCAs, base.TLSConfigDB)...)
+	problems = append(problems, checkTLS("Redis", base.Redis.TLS.Crt, base.Redis.TLS.Key, base.Redis.TLS.RootCAs, base.Redis.TLS.ClientCAs, base.TLSConfigRedis)...)
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+func describeFieldError(t reflect.Type, fe impl.FieldError) string {
+	name := fe.Namespace()
+	value := fmt.Sprintf("%q", fmt.Sprint(fe.Value()))
+	if path, ok := lookupField(t, fe.StructNamespace()); ok {
+		if e := envName(path); e != "" {
+			name = e
+		}
+		if isSecret(path) {
+			value = redacted
+		}
+	}
+	rule := fe.Tag()
+	if fe.Param() != "" {
+		rule += "=" + fe.Param()
+	}
+	return fmt.Sprintf("%s: value %s does not satisfy %q", name, value, rule)
+}
+
+func checkTLS(name, crt, key string, rootCAs, clientCAs []string, load func() (*tls.Config, error)) []string {
+	var problems []string
+	if crt == "" && key == "" {
+		return nil
+	}
+	if crt == "" || key == "" {
+		return []string{name + " TLS: certificate and key must be configured together"}
+	}
+	if _, err := load(); err != nil {
+		problems = append(problems, fmt.Sprintf("%s TLS: %s", name, err.Error()))
+	}
+	for _, f := range append(slices.Clone(rootCAs), clientCAs...) {
+		bs, err := os.ReadFile(f)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s TLS: %s", name, err.Error()))
+			continue
+		}
+		if ok := x509.NewCertPool().AppendCertsFromPEM(bs); !ok {
+			problems = append(problems, fmt.Sprintf("%s TLS: no certificates found in %s", name, f))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mrccnt/echocore"
+	"os"
+)
+
+const usage = "usage: echocore config <check|dump|gen>"
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "config" {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	os.Exit(echocore.ConfigCommand(os.Args[2:], os.Stdout))
+}
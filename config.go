@@ -3,10 +3,16 @@ package echocore
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"github.com/caarlos0/env/v11"
+	"github.com/joho/godotenv"
 	"github.com/labstack/gommon/log"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 )
 
 const (
@@ -14,25 +20,35 @@ const (
 	logInfo  = "info"
 	logWarn  = "warn"
 	logError = "error"
+
+	tagEnv    = "env"
+	tagSecret = "secret"
+	tagReload = "reload"
+	redacted  = "******"
 )
 
+// Configurer is implemented by Config and by every struct embedding it.
+type Configurer interface {
+	Base() *Config
+}
+
 type Config struct {
 	App struct {
 		Bind         string `json:"bind"          env:"APP_BIND"          envDefault:":8082"        validate:"required"`
 		EchoTimeout  int    `json:"echo_timeout"  env:"APP_ECHO_TIMEOUT"  envDefault:"10"           validate:"required,gte=0"`
-		GzipCompr    int    `json:"gzip_compr"    env:"APP_GZIP_COMPR"    envDefault:"-1"           validate:"gzip_compr"`
-		ServerHeader string `json:"server_header" env:"APP_SERVER_HEADER" envDefault:"echocore/1.0"`
+		GzipCompr    int    `json:"gzip_compr"    env:"APP_GZIP_COMPR"    envDefault:"-1"           validate:"gzip_compr" reload:"true"`
+		ServerHeader string `json:"server_header" env:"APP_SERVER_HEADER" envDefault:"echocore/1.0"                        reload:"true"`
 	} `json:"app"`
 	Log struct {
-		Level      string   `json:"level"       env:"LOG_LEVEL"       envDefault:"info" validate:"log_level"`
-		TimeFormat string   `json:"time_format" env:"LOG_TIME_FORMAT" envDefault:"2006-01-02T15:04:05Z07:00" validate:"required"`
-		LineFormat string   `json:"line_format" env:"LOG_LINE_FORMAT" envDefault:"ANSWER [${time_custom}] [${id}] [${status}] ${method} ${uri} ${error}\n" validate:"required"`
-		SkipRoutes []string `json:"skip_routes" env:"LOG_SKIP_ROUTES"`
+		Level      string   `json:"level"       env:"LOG_LEVEL"       envDefault:"info" validate:"log_level" reload:"true"`
+		TimeFormat string   `json:"time_format" env:"LOG_TIME_FORMAT" envDefault:"2006-01-02T15:04:05Z07:00" validate:"required" reload:"true"`
+		LineFormat string   `json:"line_format" env:"LOG_LINE_FORMAT" envDefault:"ANSWER [${time_custom}] [${id}] [${status}] ${method} ${uri} ${error}\n" validate:"required" reload:"true"`
+		SkipRoutes []string `json:"skip_routes" env:"LOG_SKIP_ROUTES" reload:"true"`
 	} `json:"log"`
 	DB struct {
 		Addr      string `json:"addr"       env:"DB_ADDR"            envDefault:"localhost:3306" validate:"hostname_port"`
 		User      string `json:"user"       env:"DB_USER"            envDefault:""`
-		Pass      string `json:"pass"       env:"DB_PASS"            envDefault:""`
+		Pass      string `json:"pass"       env:"DB_PASS"            envDefault:""               secret:"true"`
 		Name      string `json:"name"       env:"DB_NAME"            envDefault:""`
 		Timezone  string `json:"timezone"   env:"DB_TIMEZONE"        envDefault:"Europe/Berlin"  validate:"timezone"`
 		Collation string `json:"collation"  env:"DB_COLLATION"       envDefault:"utf8mb4_unicode_ci"`
@@ -44,7 +60,7 @@ type Config struct {
 		MaxLife   int    `json:"max_life"   env:"DB_MAX_LIFE"        envDefault:"60"`
 		TLS       struct {
 			Crt        string             `json:"crt"         env:"DB_TLS_CRT"         envDefault:""    validate:"omitempty,file"`
-			Key        string             `json:"key"         env:"DB_TLS_KEY"         envDefault:""    validate:"omitempty,file"`
+			Key        string             `json:"key"         env:"DB_TLS_KEY"         envDefault:""    validate:"omitempty,file" secret:"true"`
 			ClientCAs  []string           `json:"client_cas"  env:"DB_TLS_CLIENT_CAS"`
 			RootCAs    []string           `json:"root_cas"    env:"DB_TLS_ROOT_CAS"`
 			SkipVerify bool               `json:"skip_verify" env:"DB_TLS_SKIP_VERIFY"`
@@ -55,10 +71,10 @@ type Config struct {
 	Redis struct {
 		Addr string `json:"addr" env:"REDIS_ADDR" envDefault:"localhost:6379" validate:"hostname_port"`
 		User string `json:"user" env:"REDIS_USER" envDefault:""`
-		Pass string `json:"pass" env:"REDIS_PASS" envDefault:""`
+		Pass string `json:"pass" env:"REDIS_PASS" envDefault:""               secret:"true"`
 		TLS  struct {
 			Crt        string             `json:"crt"         env:"REDIS_TLS_CRT"         envDefault:""    validate:"omitempty,file"`
-			Key        string             `json:"key"         env:"REDIS_TLS_KEY"         envDefault:""    validate:"omitempty,file"`
+			Key        string             `json:"key"         env:"REDIS_TLS_KEY"         envDefault:""    validate:"omitempty,file" secret:"true"`
 			ClientCAs  []string           `json:"client_cas"  env:"REDIS_TLS_CLIENT_CAS"`
 			RootCAs    []string           `json:"root_cas"    env:"REDIS_TLS_ROOT_CAS"`
 			SkipVerify bool               `json:"skip_verify" env:"REDIS_TLS_SKIP_VERIFY"`
@@ -67,15 +83,76 @@ type Config struct {
 		} `json:"tls"`
 	} `json:"redis"`
 	Session struct {
-		Path     string        `json:"path"      env:"SESS_PATH"         envDefault:"/"         validate:"required,gte=1"`
-		Domain   string        `json:"domain"    env:"SESS_DOMAIN"       envDefault:"localhost" validate:"required"`
-		MaxAge   int           `json:"max_age"   env:"SESS_MAX_AGE"      envDefault:"0"`
-		Secure   bool          `json:"secure"    env:"SESS_SECURE"       envDefault:"false"`
-		HTTPOnly bool          `json:"http_only" env:"SESS_HTTP_ONLY"    envDefault:"true"`
-		SameSite http.SameSite `json:"same_site" env:"SESS_SAME_SITE"    envDefault:"1"         validate:"required,gte=1,lte=4"`
-		SessID   string        `json:"sess_id"   env:"SESS_SESS_ID"      envDefault:"id"        validate:"required,gte=1,lte=64"`
-		Seconds  int           `json:"seconds"   env:"SESS_SESS_SECONDS" envDefault:"600"       validate:"required,gte=1"`
+		Store           string        `json:"store"            env:"SESS_STORE"            envDefault:"redis"     validate:"oneof=redis memory"`
+		Path            string        `json:"path"             env:"SESS_PATH"             envDefault:"/"         validate:"required,gte=1"`
+		Domain          string        `json:"domain"           env:"SESS_DOMAIN"           envDefault:"localhost" validate:"required"`
+		MaxAge          int           `json:"max_age"          env:"SESS_MAX_AGE"          envDefault:"0"`
+		Secure          bool          `json:"secure"           env:"SESS_SECURE"           envDefault:"false"`
+		HTTPOnly        bool          `json:"http_only"        env:"SESS_HTTP_ONLY"        envDefault:"true"`
+		SameSite        http.SameSite `json:"same_site"        env:"SESS_SAME_SITE"        envDefault:"1"         validate:"required,gte=1,lte=4"`
+		SessID          string        `json:"sess_id"          env:"SESS_SESS_ID"          envDefault:"id"        validate:"required,gte=1,lte=64"`
+		Seconds         int           `json:"seconds"          env:"SESS_SESS_SECONDS"     envDefault:"600"       validate:"required,gte=1"`
+		MaxLifetime     int           `json:"max_lifetime"     env:"SESS_MAX_LIFETIME"     envDefault:"86400"     validate:"omitempty,gtefield=Seconds"`
+		Serializer      string        `json:"serializer"       env:"SESS_SERIALIZER"       envDefault:"gob"       validate:"oneof=gob json msgpack"`
+		RegenerateGrace int           `json:"regenerate_grace" env:"SESS_REGENERATE_GRACE" envDefault:"0"         validate:"gte=0"`
+		UserKey         string        `json:"user_key"         env:"SESS_USER_KEY"         envDefault:""`
+		EncryptionKeys  []string      `json:"encryption_keys"  env:"SESS_ENCRYPTION_KEYS"  envDefault:""          secret:"true"`
+		SigningKeys     []string      `json:"signing_keys"     env:"SESS_SIGNING_KEYS"     envDefault:""          secret:"true"`
+		AcceptUnsigned  bool          `json:"accept_unsigned"  env:"SESS_ACCEPT_UNSIGNED"  envDefault:"false"`
 	} `json:"session"`
+	Cron struct {
+		Timezone  string `json:"timezone"   env:"CRON_TIMEZONE"   envDefault:""      validate:"omitempty,timezone"`
+		KeyPrefix string `json:"key_prefix" env:"CRON_KEY_PREFIX" envDefault:"cron:" validate:"required"`
+		History   int    `json:"history"    env:"CRON_HISTORY"    envDefault:"50"    validate:"gte=0"`
+	} `json:"cron"`
+	Queue struct {
+		Name        string `json:"name"         env:"QUEUE_NAME"         envDefault:"default" validate:"required"`
+		KeyPrefix   string `json:"key_prefix"   env:"QUEUE_KEY_PREFIX"   envDefault:"queue:"  validate:"required"`
+		Concurrency int    `json:"concurrency"  env:"QUEUE_CONCURRENCY"  envDefault:"4"       validate:"gte=1"`
+		PollMs      int    `json:"poll_ms"      env:"QUEUE_POLL_MS"      envDefault:"1000"    validate:"gte=10"`
+		Visibility  int    `json:"visibility"   env:"QUEUE_VISIBILITY"   envDefault:"60"      validate:"gte=1"`
+		MaxAttempts int    `json:"max_attempts" env:"QUEUE_MAX_ATTEMPTS" envDefault:"5"       validate:"gte=1"`
+		Backoff     int    `json:"backoff"      env:"QUEUE_BACKOFF"      envDefault:"5"       validate:"gte=1"`
+		DeadMax     int    `json:"dead_max"     env:"QUEUE_DEAD_MAX"     envDefault:"1000"    validate:"gte=1"`
+	} `json:"queue"`
+	Lock struct {
+		KeyPrefix string `json:"key_prefix" env:"LOCK_KEY_PREFIX" envDefault:"lock:" validate:"required"`
+		TTL       int    `json:"ttl"        env:"LOCK_TTL"        envDefault:"30"    validate:"gte=1"`
+		RetryMs   int    `json:"retry_ms"   env:"LOCK_RETRY_MS"   envDefault:"100"   validate:"gte=1"`
+	} `json:"lock"`
+	HTTPCache struct {
+		KeyPrefix string `json:"key_prefix" env:"HTTP_CACHE_KEY_PREFIX" envDefault:"httpcache:" validate:"required"`
+		TTL       int    `json:"ttl"        env:"HTTP_CACHE_TTL"        envDefault:"60"         validate:"gte=0"`
+		MaxBody   int    `json:"max_body"   env:"HTTP_CACHE_MAX_BODY"   envDefault:"1048576"    validate:"gte=0"`
+	} `json:"http_cache"`
+	Cache struct {
+		KeyPrefix string `json:"key_prefix" env:"CACHE_KEY_PREFIX" envDefault:"cache:" validate:"required"`
+	} `json:"cache"`
+	Bus struct {
+		ChannelPrefix string `json:"channel_prefix" env:"BUS_CHANNEL_PREFIX" envDefault:"bus:" validate:"required"`
+	} `json:"bus"`
+	SSE struct {
+		KeyPrefix    string `json:"key_prefix"     env:"SSE_KEY_PREFIX"     envDefault:"sse:" validate:"required"`
+		Heartbeat    int    `json:"heartbeat"      env:"SSE_HEARTBEAT"      envDefault:"15"   validate:"gte=0"`
+		RetryMs      int    `json:"retry_ms"       env:"SSE_RETRY_MS"       envDefault:"3000" validate:"gte=0"`
+		StreamMaxLen int    `json:"stream_max_len" env:"SSE_STREAM_MAX_LEN" envDefault:"1000" validate:"gt=0"`
+	} `json:"sse"`
+	WS struct {
+		Topic        string `json:"topic"         env:"WS_TOPIC"         envDefault:"ws"    validate:"required"`
+		BufferSize   int    `json:"buffer_size"   env:"WS_BUFFER_SIZE"   envDefault:"1024"  validate:"gt=0"`
+		SendBuffer   int    `json:"send_buffer"   env:"WS_SEND_BUFFER"   envDefault:"64"    validate:"gt=0"`
+		MaxMessage   int    `json:"max_message"   env:"WS_MAX_MESSAGE"   envDefault:"65536" validate:"gt=0"`
+		PingInterval int    `json:"ping_interval" env:"WS_PING_INTERVAL" envDefault:"30"    validate:"gt=0"`
+		PongTimeout  int    `json:"pong_timeout"  env:"WS_PONG_TIMEOUT"  envDefault:"60"    validate:"gtfield=PingInterval"`
+		WriteTimeout int    `json:"write_timeout" env:"WS_WRITE_TIMEOUT" envDefault:"10"    validate:"gt=0"`
+	} `json:"ws"`
+	Idempotency struct {
+		KeyPrefix      string `json:"key_prefix"       env:"IDEMPOTENCY_KEY_PREFIX"       envDefault:"idempotency:" validate:"required"`
+		TTL            int    `json:"ttl"              env:"IDEMPOTENCY_TTL"              envDefault:"86400"        validate:"gt=0"`
+		LockTTL        int    `json:"lock_ttl"         env:"IDEMPOTENCY_LOCK_TTL"         envDefault:"60"           validate:"gt=0"`
+		MaxBody        int    `json:"max_body"         env:"IDEMPOTENCY_MAX_BODY"         envDefault:"1048576"      validate:"gte=0"`
+		MaxRequestBody int    `json:"max_request_body" env:"IDEMPOTENCY_MAX_REQUEST_BODY" envDefault:"1048576"      validate:"gt=0"`
+	} `json:"idempotency"`
 	CSRF struct {
 		TokenLength uint8  `json:"token_length" env:"CSRF_TOKEN_LENGTH" envDefault:"32"        validate:"gte=12"`
 		TokenLookup string `json:"token_lookup" env:"CSRF_TOKEN_LOOKUP" envDefault:"form:csrf" validate:"required"`
@@ -84,6 +161,10 @@ type Config struct {
 	} `json:"csrf"`
 }
 
+func (cfg *Config) Base() *Config {
+	return cfg
+}
+
 func (cfg *Config) GommonLevel() log.Lvl {
 	switch cfg.Log.Level {
 	case logDebug:
@@ -143,6 +224,141 @@ func (cfg *Config) IsDebug() bool {
 	return cfg.Log.Level == logDebug
 }
 
+// Dump returns the indented JSON representation of the config with all fields
+// tagged `secret:"true"` redacted.
+func (cfg *Config) Dump() ([]byte, error) {
+	return dumpConfig(cfg)
+}
+
+// procEnv holds the names of all variables set in the process environment
+// before the .env file was loaded.
+var procEnv map[string]bool
+
+func loadConfig(cfg any) error {
+	if procEnv == nil {
+		procEnv = map[string]bool{}
+		for _, kv := range os.Environ() {
+			procEnv[strings.SplitN(kv, "=", 2)[0]] = true
+		}
+	}
+	if _, err := os.Stat(".env"); err == nil {
+		if err = godotenv.Load(); err != nil {
+			return err
+		}
+	}
+	return env.Parse(cfg)
+}
+
+// reloadConfig parses cfg like loadConfig but re-reads the .env file, so
+// changed values take effect. Variables set in the process environment
+// before startup still take precedence.
+func reloadConfig(cfg any) error {
+	environ := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		environ[parts[0]] = parts[1]
+	}
+	if _, err := os.Stat(".env"); err == nil {
+		vars, err := godotenv.Read()
+		if err != nil {
+			return err
+		}
+		for k, v := range vars {
+			if !procEnv[k] {
+				environ[k] = v
+			}
+		}
+	}
+	return env.ParseWithOptions(cfg, env.Options{Environment: environ})
+}
+
+func dumpConfig(cfg any) ([]byte, error) {
+	src := reflect.ValueOf(cfg).Elem()
+	dst := reflect.New(src.Type())
+	dst.Elem().Set(src)
+	walkConfig(dst, nil, func(path []reflect.StructField, v reflect.Value) {
+		if isSecret(path) && !v.IsZero() {
+			redact(v)
+		}
+	})
+	return json.MarshalIndent(dst.Interface(), "", "  ")
+}
+
+// walkConfig calls fn for every leaf field of the config struct v. Nested
+// structs without an env tag are descended into, path holds the fields
+// leading to the leaf including the leaf itself.
+func walkConfig(v reflect.Value, path []reflect.StructField, fn func(path []reflect.StructField, v reflect.Value)) {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		p := append(slices.Clip(path), f)
+		if f.Type.Kind() == reflect.Struct && f.Tag.Get(tagEnv) == "" {
+			walkConfig(v.Field(i), p, fn)
+			continue
+		}
+		fn(p, v.Field(i))
+	}
+}
+
+// lookupField resolves a validator namespace like "Config.DB.TLS.Crt" to the
+// path of struct fields within t.
+func lookupField(t reflect.Type, ns string) ([]reflect.StructField, bool) {
+	var path []reflect.StructField
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// first element is the name of the root type
+	for _, name := range strings.Split(ns, ".")[1:] {
+		if t.Kind() != reflect.Struct {
+			return nil, false
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return nil, false
+		}
+		path = append(path, f)
+		t = f.Type
+	}
+	return path, len(path) > 0
+}
+
+func fieldIndex(path []reflect.StructField) []int {
+	idx := make([]int, 0, len(path))
+	for _, f := range path {
+		idx = append(idx, f.Index...)
+	}
+	return idx
+}
+
+func envName(path []reflect.StructField) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return strings.Split(path[len(path)-1].Tag.Get(tagEnv), ",")[0]
+}
+
+func isReloadable(path []reflect.StructField) bool {
+	return len(path) > 0 && path[len(path)-1].Tag.Get(tagReload) == "true"
+}
+
+func isSecret(path []reflect.StructField) bool {
+	return len(path) > 0 && path[len(path)-1].Tag.Get(tagSecret) == "true"
+}
+
+func redact(v reflect.Value) {
+	if v.Kind() == reflect.String {
+		v.SetString(redacted)
+		return
+	}
+	v.Set(reflect.Zero(v.Type()))
+}
+
 type tlsConfig struct {
 	Crt                string
 	Key                string
@@ -5,12 +5,12 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
-	"github.com/caarlos0/env/v11"
+	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"github.com/gorilla/sessions"
-	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/mrccnt/echocore/redstore"
@@ -24,7 +24,9 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -34,7 +36,26 @@ type Core struct {
 	Gorm      *gorm.DB
 	Redis     *redis.Client
 	SessStore *redstore.RedisStore
+	Scheduler *Scheduler
+	Queue     *Queue
+	Bus       *Bus
+	Hub       *Hub
 	TmpDir    string
+
+	app         Configurer
+	sessStore   redstore.Store
+	mu          sync.Mutex
+	live        atomic.Value
+	reloadables []*reloadable
+	hooks       []shutdownHook
+	shutdownErr error
+
+	ctx            context.Context
+	cancel         context.CancelFunc
+	ctxOnce        sync.Once
+	workers        []worker
+	workersStarted bool
+	workerWg       sync.WaitGroup
 }
 
 type InitHandler func() error
@@ -44,38 +65,49 @@ func init() {
 }
 
 func NewCore() (*Core, error) {
+	return NewCoreWith[Config]()
+}
 
-	var err error
+// NewCoreWith creates a Core from an application defined config struct T
+// embedding Config. T is parsed and validated as a whole; use AppConfig and
+// RouteConfig for typed access.
+func NewCoreWith[T any, P interface {
+	*T
+	Configurer
+}]() (*Core, error) {
 
-	if _, err = os.Stat(".env"); err == nil {
-		if err = godotenv.Load(); err != nil {
-			return nil, err
-		}
-	}
+	var err error
 
-	core := &Core{Config: new(Config)}
-	if err = env.Parse(core.Config); err != nil {
+	app := P(new(T))
+	if err = loadConfig(app); err != nil {
 		return nil, err
 	}
 
-	// if core.Config.IsDebug() {
-	//	var bs []byte
-	//	if bs, err = json.MarshalIndent(core.Config, "", "  "); err != nil {
-	//		return nil, err
-	//	} else {
-	//		fmt.Println(string(bs))
-	//	}
-	//}
-
-	if err = NewValidator().Validate(core.Config); err != nil {
+	if err = NewValidator().Validate(app); err != nil {
 		return nil, err
 	}
 
+	core := &Core{Config: app.Base(), app: app}
+
 	logrus.SetLevel(core.Config.LogrusLevel())
 
+	if core.Config.IsDebug() {
+		var bs []byte
+		if bs, err = dumpConfig(app); err != nil {
+			return nil, err
+		}
+		logrus.Debugf("[Config] %s", string(bs))
+	}
+
 	return core, nil
 }
 
+// AppConfig returns the application config the core was created with by
+// NewCoreWith. It panics if T does not match.
+func AppConfig[T any](c *Core) *T {
+	return any(c.app).(*T)
+}
+
 func NewEcho(core *Core, pre ...echo.MiddlewareFunc) *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
@@ -86,21 +118,24 @@ func NewEcho(core *Core, pre ...echo.MiddlewareFunc) *echo.Echo {
 	e.Use(middleware.Secure())
 	e.Use(middleware.RemoveTrailingSlash())
 	e.Use(middleware.RequestID())
-	e.Use(ServerHeaderMiddleware(core.Config.App.ServerHeader))
-	e.Use(GzipMiddleware(core.Config.App.GzipCompr))
+	e.Use(core.ServerHeaderMiddleware())
+	e.Use(core.GzipMiddleware())
 	e.Use(ContextMiddleware(CtxCore, core))
+	e.Use(SessionStoreMiddleware(core))
 	return e
 }
 
 func Run(core *Core, e *echo.Echo) {
 
 	chsig := make(chan os.Signal, 1)
-	signal.Notify(chsig, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(chsig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go core.ListenSig(chsig, e, &wg)
 
+	core.StartWorkers()
+
 	if err := e.Start(core.Config.App.Bind); err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			logDown(e, err.Error())
@@ -110,6 +145,11 @@ func Run(core *Core, e *echo.Echo) {
 	}
 
 	wg.Wait()
+
+	if core.shutdownErr != nil {
+		logrus.Errorf("[Shutdown] cleanup failed:\n%s", core.shutdownErr.Error())
+		os.Exit(1)
+	}
 }
 
 func (c *Core) Init(inits []InitHandler) error {
@@ -204,17 +244,40 @@ func (c *Core) InitRedis() InitHandler {
 	}
 }
 
+// Sessions returns the session store selected by SESS_STORE. SessStore is
+// only set if sessions are kept in Redis.
+func (c *Core) Sessions() redstore.Store {
+	if c.sessStore == nil && c.SessStore != nil {
+		return c.SessStore
+	}
+	return c.sessStore
+}
+
+// SetSessions replaces the session store, e.g. by a MemoryStore in tests.
+func (c *Core) SetSessions(store redstore.Store) {
+	c.sessStore = store
+	c.SessStore, _ = store.(*redstore.RedisStore)
+}
+
 func (c *Core) InitSessStore() InitHandler {
 	return func() error {
 		logInit("Session")
 
-		var err error
-		c.SessStore, err = redstore.NewRedisStore(context.Background(), c.Redis)
-		if err != nil {
-			return err
+		var store redstore.Store
+		switch c.Config.Session.Store {
+		case "memory":
+			logrus.Warnln("[Session]", "sessions are kept in memory and lost on restart")
+			store = redstore.NewMemoryStore()
+		default:
+			redisStore, err := redstore.NewRedisStore(context.Background(), c.Redis)
+			if err != nil {
+				return err
+			}
+			store = redisStore
 		}
-		c.SessStore.KeyPrefix("session:")
-		c.SessStore.Options(sessions.Options{
+		c.SetSessions(store)
+		store.KeyPrefix("session:")
+		store.Options(sessions.Options{
 			Path:     c.Config.Session.Path,
 			Domain:   c.Config.Session.Domain,
 			MaxAge:   c.Config.Session.MaxAge,
@@ -222,7 +285,37 @@ func (c *Core) InitSessStore() InitHandler {
 			HttpOnly: c.Config.Session.HTTPOnly,
 			SameSite: c.Config.Session.SameSite,
 		})
-		return nil
+		store.IdleTimeout(time.Duration(c.Config.Session.Seconds) * time.Second)
+		store.MaxLifetime(time.Duration(c.Config.Session.MaxLifetime) * time.Second)
+		store.RegenerateGrace(time.Duration(c.Config.Session.RegenerateGrace) * time.Second)
+		store.UserKey(c.Config.Session.UserKey)
+		var serializer redstore.SessionSerializer = redstore.GobSerializer{}
+		switch c.Config.Session.Serializer {
+		case "json":
+			serializer = redstore.JSONSerializer{}
+		case "msgpack":
+			serializer = redstore.MsgpackSerializer{}
+		}
+		if len(c.Config.Session.EncryptionKeys) > 0 {
+			keys, err := redstore.ParseEncryptionKeys(c.Config.Session.EncryptionKeys)
+			if err != nil {
+				return err
+			}
+			if serializer, err = redstore.NewEncryptedSerializer(serializer, keys...); err != nil {
+				return err
+			}
+		}
+		store.Serializer(serializer)
+		signingKeys := make([][]byte, 0, len(c.Config.Session.SigningKeys))
+		for _, k := range c.Config.Session.SigningKeys {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+			if err != nil {
+				return fmt.Errorf("session signing key: %w", err)
+			}
+			signingKeys = append(signingKeys, key)
+		}
+		store.AcceptUnsigned(c.Config.Session.AcceptUnsigned)
+		return store.SigningKeys(signingKeys...)
 	}
 }
 
@@ -247,49 +340,30 @@ func (c *Core) InitCopyFs(dir string, fsys fs.FS) InitHandler {
 func (c *Core) ListenSig(ch chan os.Signal, e *echo.Echo, wg *sync.WaitGroup) {
 
 	sig := <-ch
+	for sig == syscall.SIGHUP {
+		logrus.Infof("[Reload] Received: %s", sig.String())
+		if err := c.Reload(e); err != nil {
+			logrus.Errorf("[Reload] %s", err.Error())
+		}
+		sig = <-ch
+	}
 	logDown(ch, "Received: "+sig.String())
 	logDown(e, "Shutting down")
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Config.App.EchoTimeout)*time.Second)
+	c.cancelContext()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout())
 	defer cancel()
 	if err := e.Shutdown(ctx); err != nil {
 		logDown(e, err.Error())
 	}
 
-	c.Shutdown()
+	// hooks get their own budget, however long draining connections took
+	c.shutdownErr = c.Shutdown()
 
 	wg.Done()
 }
 
-func (c *Core) Shutdown() {
-
-	if c.SessStore != nil {
-		logDown(c.SessStore, "Close")
-		_ = c.SessStore.Close()
-		c.SessStore = nil
-	}
-
-	if c.Redis != nil {
-		logDown(c.Redis, "Close")
-		_ = c.Redis.Close()
-		c.Redis = nil
-	}
-
-	if c.Gorm != nil {
-		logDown(c.Gorm, "Close")
-		if db, err := c.Gorm.DB(); err == nil {
-			_ = db.Close()
-		}
-		c.Gorm = nil
-	}
-
-	if c.TmpDir != "" {
-		logDown("TmpDir", "TmpDir Cleanup")
-		_ = os.RemoveAll(c.TmpDir)
-		c.TmpDir = ""
-	}
-}
-
 func logInit(msg string) {
 	logrus.Debugf("[Init] %s", msg)
 }
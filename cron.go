@@ -0,0 +1,275 @@
+package echocore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const cronLockMinTTL = 10 * time.Millisecond
+
+// JobFunc is a scheduled job. ctx is canceled when the shutdown begins.
+type JobFunc func(ctx context.Context) error
+
+// JobRun is a single entry of the run history kept in Redis.
+type JobRun struct {
+	Job      string        `json:"job"`
+	Host     string        `json:"host"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// JobStats holds the metrics of a job collected by this instance.
+type JobStats struct {
+	Name         string        `json:"name"`
+	Spec         string        `json:"spec"`
+	Runs         int64         `json:"runs"`
+	Failures     int64         `json:"failures"`
+	Skipped      int64         `json:"skipped"`
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+	Next         time.Time     `json:"next"`
+}
+
+// Scheduler runs cron jobs on exactly one replica per tick. Each tick is
+// guarded by a lock of its own which is extended while the job runs and kept
+// until the next tick is due; a second lock per job skips ticks while a run on
+// another replica is still in progress.
+type Scheduler struct {
+	core *Core
+	cron *cron.Cron
+	loc  *time.Location
+	host string
+	mu   sync.Mutex
+	jobs map[string]*cronJob
+}
+
+type cronJob struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	fn       JobFunc
+	id       cron.EntryID
+	stats    JobStats
+}
+
+// InitScheduler creates Core.Scheduler and registers it as worker, so jobs
+// only run while the service is running. Requires InitRedis.
+func (c *Core) InitScheduler() InitHandler {
+	return func() error {
+		logInit("Scheduler")
+
+		if c.Redis == nil {
+			return errors.New("scheduler requires redis")
+		}
+
+		tz := c.Config.Cron.Timezone
+		if tz == "" {
+			tz = c.Config.DB.Timezone
+		}
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return err
+		}
+
+		host, _ := os.Hostname()
+
+		c.Scheduler = &Scheduler{
+			core: c,
+			cron: cron.New(cron.WithLocation(loc), cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger))),
+			loc:  loc,
+			host: host,
+			jobs: map[string]*cronJob{},
+		}
+		c.Worker("Scheduler", c.Scheduler.run)
+		return nil
+	}
+}
+
+// Add schedules fn using a standard cron expression (5 fields or descriptors
+// like "@daily"). Names must be unique across all replicas of the service.
+func (s *Scheduler) Add(name, spec string, fn JobFunc) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("job %s: already scheduled", name)
+	}
+
+	j := &cronJob{name: name, spec: spec, schedule: schedule, fn: fn}
+	j.stats = JobStats{Name: name, Spec: spec}
+	j.id = s.cron.Schedule(schedule, cron.FuncJob(func() { s.exec(j) }))
+	s.jobs[name] = j
+	return nil
+}
+
+// Stats returns the metrics of all jobs ordered by name.
+func (s *Scheduler) Stats() []JobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]JobStats, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		st := j.stats
+		st.Next = s.cron.Entry(j.id).Next
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Name < stats[b].Name })
+	return stats
+}
+
+// History returns the latest runs of a job across all replicas, newest first.
+func (s *Scheduler) History(ctx context.Context, name string) ([]JobRun, error) {
+	items, err := s.core.Redis.LRange(ctx, s.historyKey(name), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]JobRun, 0, len(items))
+	for _, item := range items {
+		var run JobRun
+		if err = json.Unmarshal([]byte(item), &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func (s *Scheduler) run(ctx context.Context) error {
+	s.cron.Start()
+	<-ctx.Done()
+	<-s.cron.Stop().Done()
+	return nil
+}
+
+func (s *Scheduler) exec(j *cronJob) {
+
+	now := time.Now().In(s.loc)
+	tick := s.scheduledTick(j, now)
+
+	// locks are held until the job returned, even once the shutdown began
+	ctx := context.WithoutCancel(s.core.Context())
+	locker := s.core.Locker()
+	tickLock, err := locker.TryLock(ctx, s.tickLockKey(j.name, tick))
+	if err != nil {
+		s.skip(j, err)
+		return
+	}
+	defer func() {
+		if err := tickLock.ReleaseAfter(s.keepTTL(j, tick)); err != nil {
+			logrus.Warnf("[Scheduler] [%s] lock: %s", j.name, err.Error())
+		}
+	}()
+
+	runLock, err := locker.TryLock(tickLock.Context(), s.lockKey(j.name))
+	if err != nil {
+		s.skip(j, err)
+		return
+	}
+
+	// canceled on shutdown or if a lock is lost, as the job is not exclusive
+	// anymore then
+	jobCtx, cancel := context.WithCancel(runLock.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.core.Context(), cancel)
+	defer stop()
+
+	logrus.Debugf("[Scheduler] [%s] Running", j.name)
+	run := JobRun{Job: j.name, Host: s.host, Start: now}
+	err = runWorker(jobCtx, WorkerFunc(j.fn))
+	run.Duration = time.Since(now)
+	if lockErr := runLock.Release(); lockErr != nil {
+		logrus.Warnf("[Scheduler] [%s] lock: %s", j.name, lockErr.Error())
+	}
+	if err != nil {
+		run.Error = err.Error()
+		logrus.Errorf("[Scheduler] [%s] %s", j.name, err.Error())
+	}
+
+	s.mu.Lock()
+	j.stats.Runs++
+	j.stats.LastRun = run.Start
+	j.stats.LastDuration = run.Duration
+	j.stats.LastError = run.Error
+	if err != nil {
+		j.stats.Failures++
+	}
+	s.mu.Unlock()
+
+	s.record(run)
+}
+
+func (s *Scheduler) skip(j *cronJob, err error) {
+	if !errors.Is(err, ErrLockTaken) {
+		logrus.Errorf("[Scheduler] [%s] lock: %s", j.name, err.Error())
+		return
+	}
+	s.mu.Lock()
+	j.stats.Skipped++
+	s.mu.Unlock()
+	logrus.Debugf("[Scheduler] [%s] Skipped, running on other replica", j.name)
+}
+
+func (s *Scheduler) record(run JobRun) {
+	if s.core.Config.Cron.History == 0 {
+		return
+	}
+	bs, err := json.Marshal(run)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	key := s.historyKey(run.Job)
+	if _, err = s.core.Redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, key, bs)
+		p.LTrim(ctx, key, 0, int64(s.core.Config.Cron.History-1))
+		return nil
+	}); err != nil {
+		logrus.Warnf("[Scheduler] [%s] history: %s", run.Job, err.Error())
+	}
+}
+
+// scheduledTick returns the time the running tick was scheduled for. Unlike
+// the wall clock it is the same on all replicas, even on those firing late.
+func (s *Scheduler) scheduledTick(j *cronJob, now time.Time) time.Time {
+	// the entry is updated before the cron loop serves the snapshot
+	if prev := s.cron.Entry(j.id).Prev; !prev.IsZero() && !prev.After(now) {
+		return prev
+	}
+	// ticks are due at full seconds and fire a few milliseconds later
+	return now.Truncate(time.Second)
+}
+
+// keepTTL keeps the lock of a tick until the next tick is due, so replicas
+// firing later skip this tick even if the job was quick.
+func (s *Scheduler) keepTTL(j *cronJob, tick time.Time) time.Duration {
+	return max(time.Until(j.schedule.Next(tick)), cronLockMinTTL)
+}
+
+func (s *Scheduler) lockKey(name string) string {
+	return s.core.Config.Cron.KeyPrefix + name
+}
+
+func (s *Scheduler) tickLockKey(name string, tick time.Time) string {
+	return s.lockKey(name) + ":" + strconv.FormatInt(tick.Unix(), 10)
+}
+
+func (s *Scheduler) historyKey(name string) string {
+	return s.core.Config.Cron.KeyPrefix + "history:" + name
+}
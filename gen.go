@@ -0,0 +1,286 @@
+package echocore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//go:generate go run ./cmd/echocore config gen md docs/config.md
+//go:generate go run ./cmd/echocore config gen env docs/env.sample
+//go:generate go run ./cmd/echocore config gen schema docs/config.schema.json
+
+const (
+	genMarkdown = "md"
+	genEnv      = "env"
+	genSchema   = "schema"
+
+	tagJSON       = "json"
+	tagDefault    = "envDefault"
+	tagValidate   = "validate"
+	tagSeparator  = "envSeparator"
+	jsonSchemaURI = "https://json-schema.org/draft/2020-12/schema"
+)
+
+// WriteConfigMarkdown writes a Markdown reference of all environment variables
+// declared by cfg, which must be a pointer to Config or to a struct embedding it.
+func WriteConfigMarkdown(w io.Writer, cfg any) error {
+	var (
+		b       strings.Builder
+		section string
+	)
+	walkConfig(reflect.New(configType(cfg)), nil, func(path []reflect.StructField, _ reflect.Value) {
+		if s := sectionName(path); s != section {
+			section = s
+			b.WriteString("\n## " + section + "\n\n")
+			b.WriteString("| Variable | Type | Default | Validation | Reloadable | Secret |\n")
+			b.WriteString("|----------|------|---------|------------|------------|--------|\n")
+		}
+		leaf := path[len(path)-1]
+		_, _ = fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s |\n",
+			envName(path),
+			leaf.Type.String(),
+			mdCode(leaf.Tag.Get(tagDefault)),
+			mdCode(leaf.Tag.Get(tagValidate)),
+			yesNo(isReloadable(path)),
+			yesNo(isSecret(path)),
+		)
+	})
+	_, err := io.WriteString(w, "# Configuration\n"+b.String())
+	return err
+}
+
+// WriteConfigEnv writes a sample .env file holding the defaults of all
+// environment variables declared by cfg. Secrets are left empty.
+func WriteConfigEnv(w io.Writer, cfg any) error {
+	var (
+		b       strings.Builder
+		section string
+	)
+	walkConfig(reflect.New(configType(cfg)), nil, func(path []reflect.StructField, _ reflect.Value) {
+		if s := sectionName(path); s != section {
+			if section != "" {
+				b.WriteString("\n")
+			}
+			section = s
+			b.WriteString("# " + section + "\n")
+		}
+		leaf := path[len(path)-1]
+		if v := leaf.Tag.Get(tagValidate); v != "" {
+			b.WriteString("# validate: " + v + "\n")
+		}
+		def := leaf.Tag.Get(tagDefault)
+		if isSecret(path) {
+			def = ""
+		}
+		b.WriteString(envName(path) + "=" + envQuote(def) + "\n")
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ConfigJSONSchema returns a JSON Schema describing the JSON representation of
+// cfg as produced by Config.Dump.
+func ConfigJSONSchema(cfg any) ([]byte, error) {
+	schema := schemaObject(configType(cfg))
+	schema["$schema"] = jsonSchemaURI
+	schema["title"] = configType(cfg).Name()
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func genCommand(cfg any, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: config gen <%s|%s|%s> [file]", genMarkdown, genEnv, genSchema)
+	}
+	var b strings.Builder
+	switch args[0] {
+	case genMarkdown:
+		if err := WriteConfigMarkdown(&b, cfg); err != nil {
+			return err
+		}
+	case genEnv:
+		if err := WriteConfigEnv(&b, cfg); err != nil {
+			return err
+		}
+	case genSchema:
+		bs, err := ConfigJSONSchema(cfg)
+		if err != nil {
+			return err
+		}
+		b.Write(append(bs, '\n'))
+	default:
+		return fmt.Errorf("unknown format: %s", args[0])
+	}
+	if len(args) > 1 {
+		// nolint: mnd, gosec
+		return os.WriteFile(args[1], []byte(b.String()), 0644)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func configType(cfg any) reflect.Type {
+	t := reflect.TypeOf(cfg)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func sectionName(path []reflect.StructField) string {
+	for _, f := range path[:len(path)-1] {
+		if !f.Anonymous {
+			return f.Name
+		}
+	}
+	return "General"
+}
+
+func schemaObject(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	addSchemaProperties(t, props)
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func addSchemaProperties(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get(tagJSON), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addSchemaProperties(f.Type, props)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if f.Type.Kind() == reflect.Struct && f.Tag.Get(tagEnv) == "" {
+			props[name] = schemaObject(f.Type)
+			continue
+		}
+		props[name] = schemaField(f)
+	}
+}
+
+func schemaField(f reflect.StructField) map[string]any {
+	s := map[string]any{"type": schemaType(f.Type)}
+	if f.Type.Kind() == reflect.Slice {
+		s["items"] = map[string]any{"type": schemaType(f.Type.Elem())}
+	}
+
+	desc := "env: " + envName([]reflect.StructField{f})
+	if v := f.Tag.Get(tagValidate); v != "" {
+		desc += ", validate: " + v
+	}
+	s["description"] = desc
+
+	if f.Tag.Get(tagSecret) == "true" {
+		s["writeOnly"] = true
+	}
+	if def, ok := f.Tag.Lookup(tagDefault); ok {
+		if v, ok := schemaDefault(f, def); ok {
+			s["default"] = v
+		}
+	}
+	for _, rule := range strings.Split(f.Tag.Get(tagValidate), ",") {
+		k, v, _ := strings.Cut(rule, "=")
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		numeric := s["type"] == "integer" || s["type"] == "number"
+		switch {
+		case k == "gte" && numeric:
+			s["minimum"] = n
+		case k == "lte" && numeric:
+			s["maximum"] = n
+		case k == "gte" && s["type"] == "string":
+			s["minLength"] = n
+		case k == "lte" && s["type"] == "string":
+			s["maxLength"] = n
+		}
+	}
+	return s
+}
+
+func schemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "string"
+	}
+}
+
+func schemaDefault(f reflect.StructField, def string) (any, bool) {
+	switch schemaType(f.Type) {
+	case "boolean":
+		v, err := strconv.ParseBool(def)
+		return v, err == nil
+	case "integer":
+		v, err := strconv.ParseInt(def, 10, 64)
+		return v, err == nil
+	case "number":
+		v, err := strconv.ParseFloat(def, 64)
+		return v, err == nil
+	case "array":
+		if def == "" {
+			return []string{}, true
+		}
+		sep := f.Tag.Get(tagSeparator)
+		if sep == "" {
+			sep = ","
+		}
+		return strings.Split(def, sep), true
+	case "string":
+		return def, true
+	default:
+		return nil, false
+	}
+}
+
+func mdCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return "`" + s + "`"
+}
+
+func envQuote(s string) string {
+	if s == "" || !strings.ContainsAny(s, " \t\n\"'#$\\") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
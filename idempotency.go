@@ -0,0 +1,215 @@
+package echocore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	idempotencyTokenLen     = 16
+	idempotencyMaxKeyLen    = 255
+	idempotencyStoreTimeout = time.Second
+)
+
+// KEYS: key
+// ARGV: token, fingerprint, lock ttl (ms)
+// returns: {0} if acquired, else {1, fingerprint, response}
+var idempotencyAcquire = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	local data = redis.call('HMGET', KEYS[1], 'fingerprint', 'response')
+	return {1, data[1] or '', data[2] or ''}
+end
+redis.call('HSET', KEYS[1], 'token', ARGV[1], 'fingerprint', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {0}
+`)
+
+// KEYS: key
+// ARGV: token, response, ttl (ms)
+var idempotencyComplete = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') == ARGV[1] then
+	redis.call('HSET', KEYS[1], 'response', ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// KEYS: key
+// ARGV: token
+var idempotencyAbort = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+var errIdempotencyBodyTooLarge = errors.New("request body too large for idempotency key")
+
+type IdempotencyConfig struct {
+	Skipper middleware.Skipper
+	// Scope separates keys of different clients, e.g. RateLimitBySession.
+	// Keys are global if nil.
+	Scope func(c echo.Context) string
+	// TTL overrides IDEMPOTENCY_TTL.
+	TTL time.Duration
+}
+
+// IdempotencyMiddleware makes unsafe requests carrying an Idempotency-Key
+// header safe to retry. The first response is stored in Core.Redis and
+// replayed for retries with the same key and request fingerprint (method,
+// URI and body). Retries while the first request is in flight get 409,
+// reusing a key for a different request gets 422 and bodies exceeding
+// IDEMPOTENCY_MAX_REQUEST_BODY get 413. Failed requests (errors and 5xx
+// responses) are not stored, so they can be retried. Set-Cookie headers are
+// not stored either and thus missing from replayed responses.
+func IdempotencyMiddleware(core *Core, cfg IdempotencyConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = middleware.DefaultSkipper
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = time.Duration(core.Config.Idempotency.TTL) * time.Second
+	}
+	lockTTL := time.Duration(core.Config.Idempotency.LockTTL) * time.Second
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			idemKey := req.Header.Get(HeaderIdempotencyKey)
+			if cfg.Skipper(c) || idemKey == "" || !isUnsafeMethod(req.Method) {
+				return next(c)
+			}
+
+			route := NewRoute(c)
+			if len(idemKey) > idempotencyMaxKeyLen {
+				return route.Problem(http.StatusBadRequest, "idempotency key too long")
+			}
+
+			fingerprint, err := idempotencyFingerprint(req, int64(core.Config.Idempotency.MaxRequestBody))
+			if errors.Is(err, errIdempotencyBodyTooLarge) {
+				return route.Problem(http.StatusRequestEntityTooLarge, err.Error())
+			} else if err != nil {
+				return err
+			}
+
+			scope := ""
+			if cfg.Scope != nil {
+				scope = cfg.Scope(c)
+			}
+			key := core.Config.Idempotency.KeyPrefix + scope + ":" + idemKey
+
+			bs := make([]byte, idempotencyTokenLen)
+			if _, err = rand.Read(bs); err != nil {
+				return err
+			}
+			token := hex.EncodeToString(bs)
+
+			vals, err := idempotencyAcquire.Run(req.Context(), core.Redis, []string{key}, token, fingerprint, lockTTL.Milliseconds()).Slice()
+			if err != nil {
+				logrus.Warnln("[IdempotencyMiddleware]", err.Error())
+				return next(c)
+			}
+
+			if acquired, _ := vals[0].(int64); acquired != 0 {
+				// nolint: mnd
+				if len(vals) != 3 {
+					return fmt.Errorf("unexpected idempotency result: %v", vals)
+				}
+				storedPrint, _ := vals[1].(string)
+				stored, _ := vals[2].(string)
+				switch {
+				case storedPrint != fingerprint:
+					return route.Problem(http.StatusUnprocessableEntity, "idempotency key was used for a different request")
+				case stored == "":
+					c.Response().Header().Set(HeaderRetryAfter, "1")
+					return route.Problem(http.StatusConflict, "request with this idempotency key is in progress")
+				}
+				entry := new(cachedResponse)
+				if err = json.Unmarshal([]byte(stored), entry); err != nil {
+					return err
+				}
+				c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+				return writeCachedResponse(c, entry)
+			}
+
+			rec := newResponseRecorder(c.Response().Writer, core.Config.Idempotency.MaxBody)
+			c.Response().Writer = rec
+			err = next(c)
+			c.Response().Writer = rec.ResponseWriter
+
+			ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+			defer cancel()
+
+			res := c.Response()
+			if err != nil || !res.Committed || res.Status >= http.StatusInternalServerError || rec.overflow {
+				if rec.overflow {
+					logrus.Warnf("[IdempotencyMiddleware] [%s] response exceeds %d bytes", idemKey, rec.limit)
+				}
+				if abortErr := idempotencyAbort.Run(ctx, core.Redis, []string{key}, token).Err(); abortErr != nil {
+					logrus.Warnln("[IdempotencyMiddleware]", abortErr.Error())
+				}
+				return err
+			}
+
+			header := res.Header().Clone()
+			// cookies, e.g. a new session, must not be handed out again
+			header.Del(echo.HeaderSetCookie)
+			stored, err := json.Marshal(&cachedResponse{
+				Status: res.Status,
+				Header: header,
+				Body:   rec.body.Bytes(),
+				Stored: time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			if err = idempotencyComplete.Run(ctx, core.Redis, []string{key}, token, stored, cfg.TTL.Milliseconds()).Err(); err != nil {
+				logrus.Warnln("[IdempotencyMiddleware]", err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// idempotencyFingerprint hashes method, URI and body, which is read up to
+// limit bytes and restored for the handler
+func idempotencyFingerprint(req *http.Request, limit int64) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n"))
+	if req.Body != nil {
+		bs, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+		if err != nil {
+			return "", err
+		}
+		if int64(len(bs)) > limit {
+			return "", errIdempotencyBodyTooLarge
+		}
+		req.Body = io.NopCloser(bytes.NewReader(bs))
+		h.Write(bs)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
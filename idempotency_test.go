@@ -0,0 +1,138 @@
+package echocore
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestIdempotency(t *testing.T) (*echo.Echo, *Core) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	core := &Core{Config: &Config{}, Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	t.Cleanup(func() { _ = core.Redis.Close() })
+	cfg := &core.Config.Idempotency
+	cfg.KeyPrefix, cfg.TTL, cfg.LockTTL, cfg.MaxBody, cfg.MaxRequestBody = "idempotency:", 60, 10, 1024, 16
+
+	e := echo.New()
+	e.Use(IdempotencyMiddleware(core, IdempotencyConfig{}))
+	return e, core
+}
+
+func postIdempotent(e *echo.Echo, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set(HeaderIdempotencyKey, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	e, _ := newTestIdempotency(t)
+	var calls atomic.Int32
+	e.POST("/orders", func(c echo.Context) error {
+		c.SetCookie(&http.Cookie{Name: "sid", Value: "new"})
+		return c.String(http.StatusCreated, "order "+strconv.Itoa(int(calls.Add(1))))
+	})
+
+	first := postIdempotent(e, "k1", "{}")
+	if first.Code != http.StatusCreated || first.Body.String() != "order 1" {
+		t.Fatalf("first: got %d %q", first.Code, first.Body.String())
+	}
+
+	retry := postIdempotent(e, "k1", "{}")
+	if retry.Code != http.StatusCreated || retry.Body.String() != "order 1" {
+		t.Fatalf("retry: got %d %q, want replay", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Fatal("retry: missing replay header")
+	}
+	if len(retry.Result().Cookies()) != 0 {
+		t.Fatal("retry: cookie replayed")
+	}
+
+	if other := postIdempotent(e, "k2", "{}"); other.Body.String() != "order 2" {
+		t.Fatalf("other key: got %q", other.Body.String())
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	e, _ := newTestIdempotency(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	e.POST("/orders", func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.NoContent(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postIdempotent(e, "k1", "{}") }()
+	<-started
+
+	retry := postIdempotent(e, "k1", "{}")
+	if retry.Code != http.StatusConflict || retry.Header().Get(HeaderRetryAfter) == "" {
+		t.Fatalf("retry in flight: got %d, want %d with Retry-After", retry.Code, http.StatusConflict)
+	}
+	close(release)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Fatalf("first: got %d", first.Code)
+	}
+}
+
+func TestIdempotencyDifferentRequest(t *testing.T) {
+	e, _ := newTestIdempotency(t)
+	e.POST("/orders", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	})
+
+	if rec := postIdempotent(e, "k1", `{"n":1}`); rec.Code != http.StatusCreated {
+		t.Fatalf("first: got %d", rec.Code)
+	}
+	if rec := postIdempotent(e, "k1", `{"n":2}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("different body: got %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	e, core := newTestIdempotency(t)
+	var calls atomic.Int32
+	e.POST("/orders", func(c echo.Context) error {
+		calls.Add(1)
+		return c.NoContent(http.StatusCreated)
+	})
+
+	if rec := postIdempotent(e, "k1", strings.Repeat("x", 17)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if calls.Load() != 0 {
+		t.Fatal("handler called")
+	}
+	if n := core.Redis.Exists(context.Background(), "idempotency::k1").Val(); n != 0 {
+		t.Fatal("key stored for rejected request")
+	}
+}
+
+func TestIdempotencyFailedRequest(t *testing.T) {
+	e, _ := newTestIdempotency(t)
+	var calls atomic.Int32
+	e.POST("/orders", func(c echo.Context) error {
+		if calls.Add(1) == 1 {
+			return c.NoContent(http.StatusServiceUnavailable)
+		}
+		return c.NoContent(http.StatusCreated)
+	})
+
+	if rec := postIdempotent(e, "k1", "{}"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first: got %d", rec.Code)
+	}
+	if rec := postIdempotent(e, "k1", "{}"); rec.Code != http.StatusCreated {
+		t.Fatalf("retry of failed request: got %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,189 @@
+package echocore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	lockTokenLen   = 16
+	lockOpTimeout  = 5 * time.Second
+	lockExtendRate = 3
+)
+
+var (
+	ErrLockTaken   = errors.New("lock is held by another owner")
+	ErrLockNotHeld = errors.New("lock is not held")
+)
+
+// KEYS: lock
+// ARGV: token
+var lockRelease = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// KEYS: lock
+// ARGV: token, ttl (ms)
+var lockExtend = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Locker hands out mutual exclusion locks shared by all replicas using the
+// same Redis. Each lock is owned by a random token, so only the owner can
+// release it.
+type Locker struct {
+	rdb    redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+	retry  time.Duration
+}
+
+// Lock is a held lock. Its TTL is extended automatically until it gets
+// released, the context it was acquired with is done or the lock is lost.
+type Lock struct {
+	locker *Locker
+	key    string
+	token  string
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	// expire after release instead of deleting the key
+	expireAfter time.Duration
+}
+
+func NewLocker(rdb redis.UniversalClient, prefix string, ttl, retry time.Duration) *Locker {
+	return &Locker{rdb: rdb, prefix: prefix, ttl: ttl, retry: retry}
+}
+
+// Locker returns a Locker on Core.Redis configured by the LOCK_* settings.
+func (c *Core) Locker() *Locker {
+	return NewLocker(
+		c.Redis,
+		c.Config.Lock.KeyPrefix,
+		time.Duration(c.Config.Lock.TTL)*time.Second,
+		time.Duration(c.Config.Lock.RetryMs)*time.Millisecond,
+	)
+}
+
+// Lock blocks until the lock for key is acquired or ctx is done.
+func (l *Locker) Lock(ctx context.Context, key string) (*Lock, error) {
+	for {
+		lock, err := l.TryLock(ctx, key)
+		if !errors.Is(err, ErrLockTaken) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.retry):
+		}
+	}
+}
+
+// TryLock acquires the lock for key or returns ErrLockTaken right away.
+func (l *Locker) TryLock(ctx context.Context, key string) (*Lock, error) {
+
+	bs := make([]byte, lockTokenLen)
+	if _, err := rand.Read(bs); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(bs)
+
+	ok, err := l.rdb.SetNX(ctx, l.prefix+key, token, l.ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockTaken
+	}
+
+	lock := &Lock{locker: l, key: l.prefix + key, token: token, done: make(chan struct{})}
+	lock.ctx, lock.cancel = context.WithCancel(ctx)
+	go lock.hold()
+	return lock, nil
+}
+
+// Context is canceled once the lock is released or lost. Work protected by
+// the lock should use it to stop as soon as exclusiveness is gone.
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// Release releases the lock. It returns ErrLockNotHeld if the lock expired or
+// was taken over in the meantime.
+func (l *Lock) Release() error {
+	l.cancel()
+	<-l.done
+	return l.err
+}
+
+// ReleaseAfter stops extending the lock and lets it expire after d instead of
+// deleting it, e.g. to keep others from repeating work just done.
+func (l *Lock) ReleaseAfter(d time.Duration) error {
+	l.expireAfter = d
+	return l.Release()
+}
+
+func (l *Lock) hold() {
+	defer close(l.done)
+
+	ttl := l.locker.ttl
+	ticker := time.NewTicker(ttl / lockExtendRate)
+	defer ticker.Stop()
+
+	extended := time.Now()
+	for {
+		select {
+		case <-l.ctx.Done():
+			l.release()
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), lockOpTimeout)
+		n, err := lockExtend.Run(ctx, l.locker.rdb, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+		cancel()
+
+		switch {
+		case err == nil && n == 1:
+			extended = time.Now()
+		case err == nil || time.Since(extended) >= ttl:
+			logrus.Warnf("[Lock] [%s] lost", l.key)
+			l.err = ErrLockNotHeld
+			l.cancel()
+			return
+		default:
+			logrus.Warnf("[Lock] [%s] extend: %s", l.key, err.Error())
+		}
+	}
+}
+
+func (l *Lock) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), lockOpTimeout)
+	defer cancel()
+	var n int
+	var err error
+	if l.expireAfter > 0 {
+		n, err = lockExtend.Run(ctx, l.locker.rdb, []string{l.key}, l.token, l.expireAfter.Milliseconds()).Int()
+	} else {
+		n, err = lockRelease.Run(ctx, l.locker.rdb, []string{l.key}, l.token).Int()
+	}
+	switch {
+	case err != nil:
+		l.err = err
+	case n == 0:
+		l.err = ErrLockNotHeld
+	}
+}
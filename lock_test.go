@@ -0,0 +1,146 @@
+package echocore
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestLocker(t *testing.T, ttl time.Duration) (*Locker, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return NewLocker(rdb, "lock:", ttl, 10*time.Millisecond), mr
+}
+
+func TestLockAcquireAndRefuse(t *testing.T) {
+	l, mr := newTestLocker(t, time.Second)
+	ctx := context.Background()
+
+	lock, err := l.TryLock(ctx, "a")
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	if !mr.Exists("lock:a") {
+		t.Fatal("lock key not set")
+	}
+	if _, err = l.TryLock(ctx, "a"); !errors.Is(err, ErrLockTaken) {
+		t.Fatalf("second TryLock: got %v, want ErrLockTaken", err)
+	}
+	if err = lock.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if mr.Exists("lock:a") {
+		t.Fatal("lock key not deleted on release")
+	}
+	lock, err = l.TryLock(ctx, "a")
+	if err != nil {
+		t.Fatalf("TryLock after release: %v", err)
+	}
+	_ = lock.Release()
+}
+
+func TestLockReleaseStaleToken(t *testing.T) {
+	l, mr := newTestLocker(t, time.Second)
+	ctx := context.Background()
+
+	lock, err := l.TryLock(ctx, "a")
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	// expired and taken over by another owner
+	mr.FastForward(time.Second)
+	other, err := l.TryLock(ctx, "a")
+	if err != nil {
+		t.Fatalf("TryLock by other owner: %v", err)
+	}
+	token, _ := mr.Get("lock:a")
+
+	if err = lock.Release(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Release with stale token: got %v, want ErrLockNotHeld", err)
+	}
+	if got, _ := mr.Get("lock:a"); got != token {
+		t.Fatalf("lock of other owner changed: got %q, want %q", got, token)
+	}
+	if err = other.Release(); err != nil {
+		t.Fatalf("Release by other owner: %v", err)
+	}
+}
+
+func TestLockExtend(t *testing.T) {
+	ttl := 300 * time.Millisecond
+	l, mr := newTestLocker(t, ttl)
+
+	lock, err := l.TryLock(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	// miniredis only expires keys on FastForward
+	mr.FastForward(ttl * 2 / 3)
+	deadline := time.Now().Add(time.Second)
+	for mr.TTL("lock:a") <= ttl/2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("TTL not extended: %s", mr.TTL("lock:a"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mr.FastForward(ttl * 2 / 3)
+	if !mr.Exists("lock:a") {
+		t.Fatal("lock expired although held")
+	}
+	if lock.Context().Err() != nil {
+		t.Fatal("lock context done although held")
+	}
+}
+
+func TestLockContextCanceled(t *testing.T) {
+	l, _ := newTestLocker(t, time.Second)
+
+	held, err := l.TryLock(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	defer func() { _ = held.Release() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = l.Lock(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock: got %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Lock did not give up in time")
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	ttl := 300 * time.Millisecond
+	l, mr := newTestLocker(t, ttl)
+
+	lock, err := l.TryLock(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	// taken over behind the owner's back
+	if err = mr.Set("lock:a", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lock.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("lost lock not detected")
+	}
+	if err = lock.Release(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Release: got %v, want ErrLockNotHeld", err)
+	}
+	if got, _ := mr.Get("lock:a"); got != "other" {
+		t.Fatalf("lock of other owner changed: %q", got)
+	}
+}
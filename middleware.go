@@ -1,13 +1,19 @@
 package echocore
 
 import (
+	"context"
 	"embed"
+	"github.com/gorilla/sessions"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/mrccnt/echocore/redstore"
 	"github.com/sirupsen/logrus"
+	"maps"
 	"net/http"
+	"reflect"
 	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,11 +25,27 @@ func LoggerMiddleware(tformat, lformat string, skip []string) echo.MiddlewareFun
 	})
 }
 
+// GzipMiddleware compresses responses, except event streams which must not
+// be buffered. Those are detected by the response Content-Type when the
+// header is written.
 func GzipMiddleware(level int) echo.MiddlewareFunc {
-	return middleware.GzipWithConfig(middleware.GzipConfig{
-		Skipper: middleware.DefaultSkipper,
-		Level:   level,
-	})
+	gzip := middleware.GzipWithConfig(middleware.GzipConfig{Level: level})
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			plain := c.Response().Writer
+			return gzip(func(c echo.Context) error {
+				res := c.Response()
+				compressed := res.Writer
+				res.Before(func() {
+					if res.Writer == compressed && strings.HasPrefix(res.Header().Get(echo.HeaderContentType), MIMETextEventStream) {
+						// nothing was written to the compressor yet
+						res.Writer = plain
+					}
+				})
+				return next(c)
+			})(c)
+		}
+	}
 }
 
 func ContextMiddleware(k string, i interface{}) echo.MiddlewareFunc {
@@ -61,6 +83,25 @@ func CSRFMiddleware(cfg *Config) echo.MiddlewareFunc {
 	})
 }
 
+// SessionStoreMiddleware provides Core.Sessions to redstore.Get and thereby
+// SessionMiddleware. It is registered by NewEcho; requests pass untouched as
+// long as InitSessStore did not run.
+func SessionStoreMiddleware(core *Core) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if store := core.Sessions(); store != nil {
+				redstore.Set(c, store)
+			}
+			return next(c)
+		}
+	}
+}
+
+// SessionMiddleware loads the session into the context (CtxSession) and saves
+// it right before the response is written if its values or options were
+// changed by the handler or its cookie needs to be signed, otherwise its idle
+// timeout is restarted. Nested values modified in place are not detected;
+// assign them again or call sess.Save.
 func SessionMiddleware(cfg *Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -70,11 +111,74 @@ func SessionMiddleware(cfg *Config) echo.MiddlewareFunc {
 				return next(c)
 			}
 			c.Set(CtxSession, sess)
+
+			snap := snapshotSession(sess)
+			resign := unsignedCookie(c, sess)
+			c.Response().Before(func() {
+				if !resign && !snap.modified(sess) {
+					touchSession(c, sess)
+					return
+				}
+				if err := sess.Save(c.Request(), c.Response()); err != nil {
+					logrus.Warnln("[SessionMiddleware]", "[sess.Save]", err.Error())
+				}
+			})
 			return next(c)
 		}
 	}
 }
 
+// unsignedCookie reports loaded sessions whose cookie was accepted without
+// signature and needs to be signed
+func unsignedCookie(c echo.Context, sess *sessions.Session) bool {
+	if sess.IsNew {
+		return false
+	}
+	store, ok := sess.Store().(interface {
+		UnsignedCookie(r *http.Request, session *sessions.Session) bool
+	})
+	return ok && store.UnsignedCookie(c.Request(), sess)
+}
+
+// touchSession restarts the idle timeout of stored sessions
+func touchSession(c echo.Context, sess *sessions.Session) {
+	if sess.IsNew {
+		return
+	}
+	store, ok := sess.Store().(interface {
+		Touch(ctx context.Context, session *sessions.Session) error
+	})
+	if !ok {
+		return
+	}
+	if err := store.Touch(c.Request().Context(), sess); err != nil {
+		logrus.Warnln("[SessionMiddleware]", "[Touch]", err.Error())
+	}
+}
+
+type sessionSnapshot struct {
+	values  map[interface{}]interface{}
+	options sessions.Options
+}
+
+func snapshotSession(sess *sessions.Session) *sessionSnapshot {
+	snap := &sessionSnapshot{values: maps.Clone(sess.Values)}
+	if sess.Options != nil {
+		snap.options = *sess.Options
+	}
+	return snap
+}
+
+func (snap *sessionSnapshot) modified(sess *sessions.Session) bool {
+	if sess.Options != nil && *sess.Options != snap.options {
+		return true
+	}
+	if sess.IsNew && len(sess.Values) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(snap.values, sess.Values)
+}
+
 func StaticMiddleware(docroot string, fs *embed.FS) echo.MiddlewareFunc {
 	cfg := middleware.StaticConfig{
 		Skipper: middleware.DefaultSkipper,
@@ -111,3 +215,31 @@ func SkipperRouteName(routeNames []string) func(c echo.Context) bool {
 		return false
 	}
 }
+
+// RouteName returns the name of the route matched for the current request.
+func RouteName(c echo.Context) string {
+	method, path := c.Request().Method, c.Path()
+	for _, r := range c.Echo().Routes() {
+		if r.Method == method && r.Path == path {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// routeNames looks up route names like RouteName from a map built on first
+// use, so routes need to be registered before the server starts
+type routeNames struct {
+	once  sync.Once
+	names map[string]string
+}
+
+func (rn *routeNames) get(c echo.Context) string {
+	rn.once.Do(func() {
+		rn.names = map[string]string{}
+		for _, r := range c.Echo().Routes() {
+			rn.names[r.Method+" "+r.Path] = r.Name
+		}
+	})
+	return rn.names[c.Request().Method+" "+c.Path()]
+}
@@ -0,0 +1,124 @@
+package echocore
+
+import (
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"github.com/mrccnt/echocore/redstore"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionMiddlewareMemoryStore(t *testing.T) {
+	core := &Core{Config: &Config{}}
+	core.Config.Session.SessID = "sid"
+	store := redstore.NewMemoryStore()
+	store.Options(sessions.Options{Path: "/", HttpOnly: true})
+	core.SetSessions(store)
+
+	e := echo.New()
+	e.Use(SessionStoreMiddleware(core), SessionMiddleware(core.Config))
+	e.PUT("/", func(c echo.Context) error {
+		r := NewRoute(c)
+		if err := r.Session().Set("user", c.QueryParam("user")); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/", func(c echo.Context) error {
+		r := NewRoute(c)
+		sess := r.Session()
+		user, _ := SessionGet[string](sess, "user")
+		if sess.IsNew() {
+			user = "new:" + user
+		}
+		return c.String(http.StatusOK, user)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/?user=alice", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT: got status %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "sid" || cookies[0].Value == "" {
+		t.Fatalf("PUT: got cookies %v, want session cookie", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Body.String() != "alice" {
+		t.Fatalf("GET: got %q, want %q", rec.Body.String(), "alice")
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Fatal("GET: unmodified session saved again")
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Body.String() != "new:" {
+		t.Fatalf("GET without cookie: got %q, want %q", rec.Body.String(), "new:")
+	}
+}
+
+func TestSessionMiddlewareAcceptUnsigned(t *testing.T) {
+	core := &Core{Config: &Config{}}
+	core.Config.Session.SessID = "sid"
+	store := redstore.NewMemoryStore()
+	store.Options(sessions.Options{Path: "/"})
+	core.SetSessions(store)
+
+	e := echo.New()
+	e.Use(SessionStoreMiddleware(core), SessionMiddleware(core.Config))
+	e.PUT("/", func(c echo.Context) error {
+		r := NewRoute(c)
+		if err := r.Session().Set("user", "alice"); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/", func(c echo.Context) error {
+		r := NewRoute(c)
+		user, _ := SessionGet[string](r.Session(), "user")
+		return c.String(http.StatusOK, user)
+	})
+	get := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// started before signing was enabled
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", nil))
+	unsigned := rec.Result().Cookies()[0]
+
+	if err := store.SigningKeys(make([]byte, 32)); err != nil {
+		t.Fatalf("SigningKeys: %v", err)
+	}
+	if rec = get(unsigned); rec.Body.String() != "" {
+		t.Fatalf("unsigned cookie accepted: %q", rec.Body.String())
+	}
+
+	store.AcceptUnsigned(true)
+	rec = get(unsigned)
+	if rec.Body.String() != "alice" {
+		t.Fatalf("unsigned cookie during migration: got %q, want %q", rec.Body.String(), "alice")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == unsigned.Value {
+		t.Fatalf("cookie not signed: %v", cookies)
+	}
+
+	store.AcceptUnsigned(false)
+	if rec = get(cookies[0]); rec.Body.String() != "alice" {
+		t.Fatalf("signed cookie: got %q, want %q", rec.Body.String(), "alice")
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Fatal("signed cookie issued again")
+	}
+}
@@ -0,0 +1,574 @@
+package echocore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	queueIDLen        = 16
+	queuePromoteLimit = 100
+	queueListLimit    = 50
+	queueOpTimeout    = 5 * time.Second
+	// handlers are canceled that long before the visibility deadline, so the
+	// job is acknowledged before it gets delivered again
+	queueAckMargin = time.Second
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// dequeue pops the next ready job, marks it in flight until the visibility
+// deadline, increments its attempts and stores the token of this delivery.
+//
+// KEYS: ready, inflight, jobs, attempts, tokens
+// ARGV: deadline, token
+var queueDequeue = redis.NewScript(`
+local id = redis.call('RPOP', KEYS[1])
+if not id then
+	return nil
+end
+redis.call('ZADD', KEYS[2], ARGV[1], id)
+redis.call('HSET', KEYS[5], id, ARGV[2])
+local attempts = redis.call('HINCRBY', KEYS[4], id, 1)
+return {id, redis.call('HGET', KEYS[3], id), attempts}
+`)
+
+// The following scripts finish a delivery. They do nothing and return 0 if
+// the token does not match, i.e. the job was delivered again meanwhile.
+
+// ack deletes a job.
+//
+// KEYS: tokens, inflight, jobs, attempts
+// ARGV: id, token
+var queueAck = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+return 1
+`)
+
+// release hands a job back without counting the attempt.
+//
+// KEYS: tokens, inflight, attempts, ready
+// ARGV: id, token
+var queueRelease = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HINCRBY', KEYS[3], ARGV[1], -1)
+redis.call('RPUSH', KEYS[4], ARGV[1])
+return 1
+`)
+
+// retry stores the failed job and schedules the next attempt.
+//
+// KEYS: tokens, inflight, jobs, delayed
+// ARGV: id, token, job, run at (ms)
+var queueRetry = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[3])
+redis.call('ZADD', KEYS[4], ARGV[4], ARGV[1])
+return 1
+`)
+
+// promote moves due delayed jobs and in flight jobs whose visibility timeout
+// expired back to the ready list.
+//
+// KEYS: delayed, inflight, ready
+// ARGV: now, limit
+var queuePromote = redis.NewScript(`
+local n = 0
+for i = 1, 2 do
+	local ids = redis.call('ZRANGEBYSCORE', KEYS[i], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+	for _, id in ipairs(ids) do
+		redis.call('ZREM', KEYS[i], id)
+		redis.call('LPUSH', KEYS[3], id)
+		n = n + 1
+	end
+end
+return n
+`)
+
+// bury moves a job to the dead letter list and drops the oldest dead jobs
+// exceeding the limit.
+//
+// KEYS: inflight, dead, jobs, attempts, tokens
+// ARGV: id, job, limit, token
+var queueBury = redis.NewScript(`
+if redis.call('HGET', KEYS[5], ARGV[1]) ~= ARGV[4] then
+	return 0
+end
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+redis.call('LPUSH', KEYS[2], ARGV[1])
+local limit = tonumber(ARGV[3])
+while redis.call('LLEN', KEYS[2]) > limit do
+	local id = redis.call('RPOP', KEYS[2])
+	redis.call('HDEL', KEYS[3], id)
+	redis.call('HDEL', KEYS[4], id)
+end
+return 1
+`)
+
+// revive moves a job from the dead letter list back to the ready list and
+// resets its attempts. It returns 0 if the job is not dead or its data is
+// gone.
+//
+// KEYS: dead, jobs, attempts, ready
+// ARGV: id
+var queueRevive = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+if redis.call('HEXISTS', KEYS[2], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('LPUSH', KEYS[4], ARGV[1])
+return 1
+`)
+
+// Job is the envelope stored for every enqueued job.
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	// token and visibility deadline of the current delivery
+	token    string
+	deadline time.Time
+}
+
+type JobHandler func(ctx context.Context, job *Job) error
+
+type EnqueueOption func(job *Job, runAt *time.Time)
+
+type QueueStats struct {
+	Ready    int64 `json:"ready"`
+	Delayed  int64 `json:"delayed"`
+	InFlight int64 `json:"in_flight"`
+	Dead     int64 `json:"dead"`
+}
+
+// Queue is a durable job queue in Redis with at-least-once delivery. Jobs not
+// acknowledged within the visibility timeout are delivered again, failed jobs
+// are retried with exponential backoff and finally moved to the dead letter
+// list.
+type Queue struct {
+	core     *Core
+	prefix   string
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+}
+
+// WithDelay delays the first delivery of a job.
+func WithDelay(d time.Duration) EnqueueOption {
+	return func(_ *Job, runAt *time.Time) {
+		*runAt = runAt.Add(d)
+	}
+}
+
+// WithMaxAttempts overrides QUEUE_MAX_ATTEMPTS for a job.
+func WithMaxAttempts(n int) EnqueueOption {
+	return func(job *Job, _ *time.Time) {
+		job.MaxAttempts = n
+	}
+}
+
+// InitQueue creates Core.Queue and registers QUEUE_CONCURRENCY workers
+// processing it. Requires InitRedis.
+func (c *Core) InitQueue() InitHandler {
+	return func() error {
+		logInit("Queue")
+
+		if c.Redis == nil {
+			return errors.New("queue requires redis")
+		}
+
+		c.Queue = &Queue{
+			core:     c,
+			prefix:   c.Config.Queue.KeyPrefix + c.Config.Queue.Name + ":",
+			handlers: map[string]JobHandler{},
+		}
+		for i := 0; i < c.Config.Queue.Concurrency; i++ {
+			c.Worker(fmt.Sprintf("Queue#%d", i), c.Queue.work)
+		}
+		return nil
+	}
+}
+
+// Handle registers the handler for a job type.
+func (q *Queue) Handle(jobType string, h JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+// HandleJob registers a typed handler for a job type; the payload is decoded
+// from JSON into T before fn is called.
+func HandleJob[T any](q *Queue, jobType string, fn func(ctx context.Context, payload T) error) {
+	q.Handle(jobType, func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return err
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Enqueue stores a job with the JSON encoded payload and returns its ID.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any, opts ...EnqueueOption) (string, error) {
+
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := queueID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	runAt := now
+	job := &Job{
+		ID:          id,
+		Type:        jobType,
+		Payload:     bs,
+		MaxAttempts: q.core.Config.Queue.MaxAttempts,
+		CreatedAt:   now,
+	}
+	for _, opt := range opts {
+		opt(job, &runAt)
+	}
+
+	if bs, err = json.Marshal(job); err != nil {
+		return "", err
+	}
+
+	_, err = q.core.Redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, q.key("jobs"), id, bs)
+		if runAt.After(now) {
+			p.ZAdd(ctx, q.key("delayed"), redis.Z{Score: float64(runAt.UnixMilli()), Member: id})
+		} else {
+			p.LPush(ctx, q.key("ready"), id)
+		}
+		return nil
+	})
+	return id, err
+}
+
+func (q *Queue) Stats(ctx context.Context) (QueueStats, error) {
+	var (
+		stats QueueStats
+		cmds  [4]*redis.IntCmd
+	)
+	_, err := q.core.Redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+		cmds[0] = p.LLen(ctx, q.key("ready"))
+		cmds[1] = p.ZCard(ctx, q.key("delayed"))
+		cmds[2] = p.ZCard(ctx, q.key("inflight"))
+		cmds[3] = p.LLen(ctx, q.key("dead"))
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.Ready, stats.Delayed, stats.InFlight, stats.Dead = cmds[0].Val(), cmds[1].Val(), cmds[2].Val(), cmds[3].Val()
+	return stats, nil
+}
+
+// Dead lists jobs in the dead letter list, newest first.
+func (q *Queue) Dead(ctx context.Context, offset, limit int64) ([]Job, error) {
+	ids, err := q.core.Redis.LRange(ctx, q.key("dead"), offset, offset+limit-1).Result()
+	if err != nil || len(ids) == 0 {
+		return []Job{}, err
+	}
+	vals, err := q.core.Redis.HMGet(ctx, q.key("jobs"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(vals))
+	for _, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var job Job
+		if err = json.Unmarshal([]byte(s), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Retry moves a job from the dead letter list back to the ready list and
+// resets its attempts.
+func (q *Queue) Retry(ctx context.Context, id string) error {
+	n, err := queueRevive.Run(ctx, q.core.Redis,
+		[]string{q.key("dead"), q.key("jobs"), q.key("attempts"), q.key("ready")},
+		id,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// AdminRoutes registers read-only listing endpoints plus a retry endpoint for
+// dead jobs. Protecting the group is up to the application.
+func (q *Queue) AdminRoutes(g *echo.Group) {
+	g.GET("", func(c echo.Context) error {
+		r := NewRoute(c)
+		stats, err := q.Stats(c.Request().Context())
+		if err != nil {
+			return r.Error(err)
+		}
+		return c.JSON(http.StatusOK, stats)
+	}).Name = "queue.stats"
+
+	g.GET("/dead", func(c echo.Context) error {
+		r := NewRoute(c)
+		offset, _ := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+		limit, _ := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+		if limit <= 0 {
+			limit = queueListLimit
+		}
+		jobs, err := q.Dead(c.Request().Context(), max(offset, 0), limit)
+		if err != nil {
+			return r.Error(err)
+		}
+		return c.JSON(http.StatusOK, jobs)
+	}).Name = "queue.dead"
+
+	g.POST("/dead/:id/retry", func(c echo.Context) error {
+		r := NewRoute(c)
+		if err := q.Retry(c.Request().Context(), c.Param("id")); err != nil {
+			if errors.Is(err, ErrJobNotFound) {
+				return c.JSON(http.StatusNotFound, &ServiceMessage{Message: err.Error()})
+			}
+			return r.Error(err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}).Name = "queue.retry"
+}
+
+func (q *Queue) work(ctx context.Context) error {
+	poll := time.Duration(q.core.Config.Queue.PollMs) * time.Millisecond
+	for {
+		job, err := q.next(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(poll):
+				continue
+			}
+		}
+		q.process(ctx, job)
+	}
+}
+
+func (q *Queue) next(ctx context.Context) (*Job, error) {
+
+	now := time.Now()
+	if err := queuePromote.Run(ctx, q.core.Redis,
+		[]string{q.key("delayed"), q.key("inflight"), q.key("ready")},
+		now.UnixMilli(), queuePromoteLimit,
+	).Err(); err != nil {
+		return nil, err
+	}
+
+	token, err := queueID()
+	if err != nil {
+		return nil, err
+	}
+	deadline := now.Add(q.visibility())
+	res, err := queueDequeue.Run(ctx, q.core.Redis,
+		[]string{q.key("ready"), q.key("inflight"), q.key("jobs"), q.key("attempts"), q.key("tokens")},
+		deadline.UnixMilli(), token,
+	).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := res[0].(string)
+	data, _ := res[1].(string)
+	attempts, _ := res[2].(int64)
+
+	job := &Job{ID: id}
+	err = json.Unmarshal([]byte(data), job)
+	job.token, job.deadline = token, deadline
+	if err != nil {
+		job.LastError = "invalid job: " + err.Error()
+		q.bury(job)
+		return nil, nil
+	}
+	job.Attempts = int(attempts)
+	return job, nil
+}
+
+func (q *Queue) process(ctx context.Context, job *Job) {
+
+	q.mu.RLock()
+	h, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		job.LastError = "no handler for job type " + job.Type
+		logrus.Errorf("[Queue] [%s] %s", job.ID, job.LastError)
+		q.bury(job)
+		return
+	}
+
+	// ends before the visibility deadline, so the job is not delivered again
+	// while still running
+	jctx, cancel := context.WithDeadline(ctx, job.deadline.Add(-q.ackMargin()))
+	err := runJob(jctx, h, job)
+	cancel()
+
+	switch {
+	case err == nil:
+		q.ack(job)
+	case ctx.Err() != nil:
+		// shutting down: hand the job back without counting the attempt
+		q.release(job)
+	case job.Attempts >= job.MaxAttempts:
+		job.LastError = err.Error()
+		logrus.Errorf("[Queue] [%s] [%s] %s, giving up after %d attempts", job.Type, job.ID, err.Error(), job.Attempts)
+		q.bury(job)
+	default:
+		job.LastError = err.Error()
+		logrus.Warnf("[Queue] [%s] [%s] %s, retrying", job.Type, job.ID, err.Error())
+		q.retry(job)
+	}
+}
+
+// runJob calls h and turns panics into errors. Unlike runWorker, errors
+// caused by the shutdown are kept, so the job is released instead of acked.
+func runJob(ctx context.Context, h JobHandler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (q *Queue) ack(job *Job) {
+	q.finish(job, "ack", queueAck,
+		[]string{q.key("tokens"), q.key("inflight"), q.key("jobs"), q.key("attempts")},
+		job.ID, job.token)
+}
+
+func (q *Queue) release(job *Job) {
+	q.finish(job, "release", queueRelease,
+		[]string{q.key("tokens"), q.key("inflight"), q.key("attempts"), q.key("ready")},
+		job.ID, job.token)
+}
+
+func (q *Queue) retry(job *Job) {
+	bs, err := json.Marshal(job)
+	if err != nil {
+		logrus.Errorf("[Queue] [%s] retry: %s", job.ID, err.Error())
+		return
+	}
+	runAt := time.Now().Add(q.backoff(job.Attempts))
+	q.finish(job, "retry", queueRetry,
+		[]string{q.key("tokens"), q.key("inflight"), q.key("jobs"), q.key("delayed")},
+		job.ID, job.token, bs, runAt.UnixMilli())
+}
+
+func (q *Queue) bury(job *Job) {
+	bs, err := json.Marshal(job)
+	if err != nil {
+		logrus.Errorf("[Queue] [%s] bury: %s", job.ID, err.Error())
+		return
+	}
+	q.finish(job, "bury", queueBury,
+		[]string{q.key("inflight"), q.key("dead"), q.key("jobs"), q.key("attempts"), q.key("tokens")},
+		job.ID, bs, q.core.Config.Queue.DeadMax, job.token)
+}
+
+// finish runs one of the scripts finishing a delivery
+func (q *Queue) finish(job *Job, op string, script *redis.Script, keys []string, args ...interface{}) {
+	ctx, cancel := q.opContext()
+	defer cancel()
+	n, err := script.Run(ctx, q.core.Redis, keys, args...).Int()
+	switch {
+	case err != nil:
+		logrus.Errorf("[Queue] [%s] %s: %s", job.ID, op, err.Error())
+	case n == 0:
+		logrus.Warnf("[Queue] [%s] %s: visibility timeout exceeded, job was delivered again", job.ID, op)
+	}
+}
+
+// backoff returns the delay before the next attempt: QUEUE_BACKOFF seconds
+// doubled with every failed attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := time.Duration(q.core.Config.Queue.Backoff) * time.Second
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, time.Hour)
+}
+
+func (q *Queue) visibility() time.Duration {
+	return time.Duration(q.core.Config.Queue.Visibility) * time.Second
+}
+
+// ackMargin is at most half the visibility timeout
+func (q *Queue) ackMargin() time.Duration {
+	return min(queueAckMargin, q.visibility()/2)
+}
+
+// opContext is used for bookkeeping after a job ran, which must not be
+// interrupted by the shutdown.
+func (q *Queue) opContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), queueOpTimeout)
+}
+
+func (q *Queue) key(name string) string {
+	return q.prefix + name
+}
+
+func queueID() (string, error) {
+	bs := make([]byte, queueIDLen)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
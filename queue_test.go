@@ -0,0 +1,183 @@
+package echocore
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T) (*Queue, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	core := &Core{Config: &Config{}, Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	t.Cleanup(func() { _ = core.Redis.Close() })
+	q := &core.Config.Queue
+	q.Name, q.KeyPrefix, q.Concurrency, q.PollMs = "test", "queue:", 1, 10
+	q.Visibility, q.MaxAttempts, q.Backoff, q.DeadMax = 10, 2, 1, 10
+	if err := core.InitQueue()(); err != nil {
+		t.Fatalf("InitQueue: %v", err)
+	}
+	return core.Queue, mr
+}
+
+// due makes all members of a sorted set (delayed or inflight) due now
+func due(t *testing.T, q *Queue, name string) {
+	t.Helper()
+	ctx := context.Background()
+	ids, err := q.core.Redis.ZRange(ctx, q.key(name), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		q.core.Redis.ZAdd(ctx, q.key(name), redis.Z{Score: 0, Member: id})
+	}
+}
+
+func mustNext(t *testing.T, q *Queue) *Job {
+	t.Helper()
+	job, err := q.next(context.Background())
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if job == nil {
+		t.Fatal("next: no job ready")
+	}
+	return job
+}
+
+func queueStats(t *testing.T, q *Queue) QueueStats {
+	t.Helper()
+	stats, err := q.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	return stats
+}
+
+func TestQueueRetryThenBury(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+	q.Handle("fail", func(ctx context.Context, job *Job) error { return errors.New("boom") })
+
+	id, err := q.Enqueue(ctx, "fail", nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	q.process(ctx, mustNext(t, q))
+	if s := queueStats(t, q); s.Delayed != 1 || s.InFlight != 0 {
+		t.Fatalf("after first attempt: got %+v, want job delayed", s)
+	}
+
+	due(t, q, "delayed")
+	job := mustNext(t, q)
+	if job.Attempts != 2 || job.LastError != "boom" {
+		t.Fatalf("second attempt: got attempts %d, error %q", job.Attempts, job.LastError)
+	}
+	q.process(ctx, job)
+	if s := queueStats(t, q); s.Dead != 1 || s.Delayed != 0 || s.InFlight != 0 {
+		t.Fatalf("after MaxAttempts: got %+v, want job dead", s)
+	}
+
+	if err = q.Retry(ctx, "unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("Retry of unknown job: got %v, want ErrJobNotFound", err)
+	}
+	if err = q.Retry(ctx, id); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if s := queueStats(t, q); s.Dead != 0 || s.Ready != 1 {
+		t.Fatalf("after Retry: got %+v, want job ready", s)
+	}
+	if job = mustNext(t, q); job.ID != id || job.Attempts != 1 {
+		t.Fatalf("after Retry: got job %s with %d attempts, want %s with 1", job.ID, job.Attempts, id)
+	}
+}
+
+func TestQueueRetryWithoutJobData(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+	q.core.Redis.LPush(ctx, q.key("dead"), "orphan")
+
+	if err := q.Retry(ctx, "orphan"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("Retry: got %v, want ErrJobNotFound", err)
+	}
+	if s := queueStats(t, q); s.Ready != 0 || s.Dead != 0 {
+		t.Fatalf("got %+v, want orphan dropped", s)
+	}
+}
+
+func TestQueueRedeliveryStaleAck(t *testing.T) {
+	q, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	id, err := q.Enqueue(ctx, "slow", nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	first := mustNext(t, q)
+
+	// visibility timeout expired while the first delivery still runs
+	due(t, q, "inflight")
+	second := mustNext(t, q)
+	if second.ID != id || second.Attempts != 2 || second.token == first.token {
+		t.Fatalf("redelivery: got %s with %d attempts", second.ID, second.Attempts)
+	}
+
+	q.ack(first)
+	if s := queueStats(t, q); s.InFlight != 1 {
+		t.Fatalf("stale ack: got %+v, want job still in flight", s)
+	}
+	if ok, _ := q.core.Redis.HExists(ctx, q.key("jobs"), id).Result(); !ok {
+		t.Fatal("stale ack deleted the job")
+	}
+
+	q.ack(second)
+	if s := queueStats(t, q); s.InFlight != 0 {
+		t.Fatalf("ack: got %+v, want job done", s)
+	}
+	if ok, _ := q.core.Redis.HExists(ctx, q.key("jobs"), id).Result(); ok {
+		t.Fatal("ack kept the job")
+	}
+}
+
+func TestQueueReleaseOnShutdown(t *testing.T) {
+	q, _ := newTestQueue(t)
+	started := make(chan struct{})
+	q.Handle("wait", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	id, err := q.Enqueue(context.Background(), "wait", nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	job := mustNext(t, q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	done := make(chan struct{})
+	go func() {
+		q.process(ctx, job)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("process did not return on shutdown")
+	}
+
+	if s := queueStats(t, q); s.Ready != 1 || s.InFlight != 0 {
+		t.Fatalf("after shutdown: got %+v, want job ready", s)
+	}
+	if job = mustNext(t, q); job.ID != id || job.Attempts != 1 {
+		t.Fatalf("released job: got %s with %d attempts, want %s with 1", job.ID, job.Attempts, id)
+	}
+}
@@ -0,0 +1,255 @@
+package echocore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SlidingWindow RateLimitAlgorithm = iota
+	TokenBucket
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+
+	rateLimitDefaultRoute = "_default"
+	rateLimitMemberLen    = 8
+)
+
+// KEYS: key
+// ARGV: now (ms), window (ms), limit, member
+// returns: allowed, remaining, retry (ms), reset (ms)
+var rateLimitSlidingWindow = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+return {allowed, limit - count, retry, reset}
+`)
+
+// KEYS: key
+// ARGV: now (ms), window (ms) to refill the whole bucket, capacity
+// returns: allowed, remaining, retry (ms), reset (ms)
+var rateLimitTokenBucket = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local rate = capacity / window
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or capacity
+local ts = tonumber(data[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], window)
+local retry = 0
+if allowed == 0 then
+	retry = math.ceil((1 - tokens) / rate)
+end
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+type RateLimitAlgorithm int
+
+// RateLimitKeyFunc identifies the client a request is accounted to. An empty
+// key falls back to the client IP.
+type RateLimitKeyFunc func(c echo.Context) string
+
+// RateLimitRule allows Limit requests per Window, which must be at least a
+// millisecond. For
+// TokenBucket, Limit is the bucket capacity and Window the time to refill an
+// empty bucket.
+type RateLimitRule struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+	Key       RateLimitKeyFunc
+}
+
+type RateLimitConfig struct {
+	Skipper middleware.Skipper
+	// KeyPrefix of all keys in Redis.
+	KeyPrefix string
+	// Default applies to all routes without a rule in Routes. Routes are
+	// unlimited if nil.
+	Default *RateLimitRule
+	// Routes maps route names to rules.
+	Routes map[string]RateLimitRule
+}
+
+type rateLimitResult struct {
+	allowed   bool
+	remaining int64
+	retry     time.Duration
+	reset     time.Duration
+}
+
+func RateLimitByIP(c echo.Context) string {
+	return c.RealIP()
+}
+
+// RateLimitBySession accounts requests to the session loaded by
+// SessionMiddleware.
+func RateLimitBySession(c echo.Context) string {
+	if sess, ok := c.Get(CtxSession).(*sessions.Session); ok && sess.ID != "" {
+		return "sess:" + sess.ID
+	}
+	return ""
+}
+
+// RateLimitByHeader accounts requests to the value of a header, e.g. an API
+// key. Only a hash of the value is stored in Redis.
+func RateLimitByHeader(name string) RateLimitKeyFunc {
+	return func(c echo.Context) string {
+		if v := c.Request().Header.Get(name); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return "hdr:" + hex.EncodeToString(sum[:])
+		}
+		return ""
+	}
+}
+
+// RateLimitByUser accounts requests to the user returned by fn.
+func RateLimitByUser(fn func(c echo.Context) string) RateLimitKeyFunc {
+	return func(c echo.Context) string {
+		if v := fn(c); v != "" {
+			return "user:" + v
+		}
+		return ""
+	}
+}
+
+// RateLimitMiddleware limits requests across all replicas using Redis. Rules
+// are selected by route name (see RouteName). Responses carry RateLimit-*
+// headers; rejected requests get a 429 problem response with Retry-After.
+// Requests pass if Redis is unavailable. It panics on rules with a window
+// below a millisecond, the resolution of the scripts.
+func RateLimitMiddleware(rdb redis.UniversalClient, cfg RateLimitConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = middleware.DefaultSkipper
+	}
+	if cfg.Default != nil && cfg.Default.Window < time.Millisecond {
+		panic("echo: rate limit default rule requires window of at least 1ms")
+	}
+	for name, rule := range cfg.Routes {
+		if rule.Window < time.Millisecond {
+			panic(fmt.Sprintf("echo: rate limit rule of route %q requires window of at least 1ms", name))
+		}
+	}
+	names := &routeNames{}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper(c) {
+				return next(c)
+			}
+
+			name := names.get(c)
+			rule, ok := cfg.Routes[name]
+			if !ok {
+				if cfg.Default == nil {
+					return next(c)
+				}
+				rule, name = *cfg.Default, rateLimitDefaultRoute
+			}
+
+			key := ""
+			if rule.Key != nil {
+				key = rule.Key(c)
+			}
+			if key == "" {
+				key = "ip:" + c.RealIP()
+			}
+
+			res, err := rateLimit(c, rdb, rule, cfg.KeyPrefix+name+":"+key)
+			if err != nil {
+				logrus.Warnln("[RateLimitMiddleware]", err.Error())
+				return next(c)
+			}
+
+			h := c.Response().Header()
+			h.Set(HeaderRateLimitLimit, strconv.Itoa(rule.Limit))
+			h.Set(HeaderRateLimitRemaining, strconv.FormatInt(max(res.remaining, 0), 10))
+			h.Set(HeaderRateLimitReset, ceilSeconds(res.reset))
+
+			if !res.allowed {
+				h.Set(HeaderRetryAfter, ceilSeconds(res.retry))
+				route := NewRoute(c)
+				return route.Problem(http.StatusTooManyRequests, fmt.Sprintf("rate limit of %d requests per %s exceeded", rule.Limit, rule.Window))
+			}
+			return next(c)
+		}
+	}
+}
+
+func rateLimit(c echo.Context, rdb redis.UniversalClient, rule RateLimitRule, key string) (*rateLimitResult, error) {
+
+	ctx := c.Request().Context()
+	now := time.Now().UnixMilli()
+
+	var cmd *redis.Cmd
+	switch rule.Algorithm {
+	case TokenBucket:
+		cmd = rateLimitTokenBucket.Run(ctx, rdb, []string{key}, now, rule.Window.Milliseconds(), rule.Limit)
+	default:
+		bs := make([]byte, rateLimitMemberLen)
+		if _, err := rand.Read(bs); err != nil {
+			return nil, err
+		}
+		cmd = rateLimitSlidingWindow.Run(ctx, rdb, []string{key}, now, rule.Window.Milliseconds(), rule.Limit, hex.EncodeToString(bs))
+	}
+
+	vals, err := cmd.Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	// nolint: mnd
+	if len(vals) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result: %v", vals)
+	}
+	return &rateLimitResult{
+		allowed:   vals[0] == 1,
+		remaining: vals[1],
+		retry:     time.Duration(vals[2]) * time.Millisecond,
+		reset:     time.Duration(vals[3]) * time.Millisecond,
+	}, nil
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,125 @@
+package echocore
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRateLimit(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return rdb
+}
+
+// runRateLimit runs a rate limit script at now and returns allowed,
+// remaining, retry and reset
+func runRateLimit(t *testing.T, rdb redis.UniversalClient, script *redis.Script, now int64, args ...any) []int64 {
+	t.Helper()
+	vals, err := script.Run(context.Background(), rdb, []string{"rl"}, append([]any{now}, args...)...).Int64Slice()
+	if err != nil {
+		t.Fatalf("script at %d: %v", now, err)
+	}
+	return vals
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	rdb := newTestRateLimit(t)
+
+	for _, tt := range []struct {
+		now    int64
+		member string
+		want   []int64
+	}{
+		{now: 0, member: "a", want: []int64{1, 1, 0, 1000}},
+		{now: 100, member: "b", want: []int64{1, 0, 0, 900}},
+		{now: 200, member: "c", want: []int64{0, 0, 800, 800}},
+		// the first request left the window
+		{now: 1000, member: "d", want: []int64{1, 0, 0, 100}},
+		{now: 1050, member: "e", want: []int64{0, 0, 50, 50}},
+	} {
+		got := runRateLimit(t, rdb, rateLimitSlidingWindow, tt.now, 1000, 2, tt.member)
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("at %d: got %v, want %v", tt.now, got, tt.want)
+		}
+	}
+	if ttl := rdb.PTTL(context.Background(), "rl").Val(); ttl <= 0 || ttl > time.Second {
+		t.Fatalf("got TTL %s, want at most the window", ttl)
+	}
+}
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	rdb := newTestRateLimit(t)
+
+	for _, tt := range []struct {
+		now  int64
+		want []int64
+	}{
+		{now: 0, want: []int64{1, 1, 0, 500}},
+		{now: 0, want: []int64{1, 0, 0, 1000}},
+		{now: 0, want: []int64{0, 0, 500, 1000}},
+		// refilled half a token
+		{now: 250, want: []int64{0, 0, 250, 750}},
+		{now: 600, want: []int64{1, 0, 0, 900}},
+		// refilled the whole bucket, but not beyond its capacity
+		{now: 5000, want: []int64{1, 1, 0, 500}},
+	} {
+		got := runRateLimit(t, rdb, rateLimitTokenBucket, tt.now, 1000, 2)
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("at %d: got %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rdb := newTestRateLimit(t)
+	e := echo.New()
+	e.Use(RateLimitMiddleware(rdb, RateLimitConfig{
+		KeyPrefix: "ratelimit:",
+		Default:   &RateLimitRule{Limit: 1, Window: time.Minute, Key: RateLimitByHeader("X-Api-Key")},
+	}))
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for _, want := range []int{http.StatusNoContent, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", "secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("got status %d, want %d", rec.Code, want)
+		}
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("other client: got status %d", rec.Code)
+	}
+
+	for _, key := range rdb.Keys(context.Background(), "*").Val() {
+		if strings.Contains(key, "secret") {
+			t.Fatalf("API key stored in key %q", key)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareWindow(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic on window below 1ms")
+		}
+	}()
+	RateLimitMiddleware(nil, RateLimitConfig{
+		Routes: map[string]RateLimitRule{"login": {Limit: 5, Window: time.Microsecond}},
+	})
+}
@@ -0,0 +1,148 @@
+package redstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	formatEncrypted = 'e'
+	maxKeyIDLen     = 255
+)
+
+// EncryptionKey is an AES key (16, 24 or 32 bytes) identified by ID.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// EncryptedSerializer encrypts and authenticates the output of another
+// serializer with AES-GCM. The first key encrypts, all keys decrypt, so keys
+// can be rotated by prepending a new one. Sessions encrypted with an older
+// key or stored unencrypted are rewritten with the first key when loaded.
+type EncryptedSerializer struct {
+	inner   SessionSerializer
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewEncryptedSerializer wraps inner, see EncryptedSerializer.
+func NewEncryptedSerializer(inner SessionSerializer, keys ...EncryptionKey) (*EncryptedSerializer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("redstore: encryption requires at least one key")
+	}
+	es := &EncryptedSerializer{inner: inner, primary: keys[0].ID, aeads: map[string]cipher.AEAD{}}
+	for _, k := range keys {
+		if k.ID == "" || len(k.ID) > maxKeyIDLen {
+			return nil, fmt.Errorf("redstore: invalid encryption key id %q", k.ID)
+		}
+		if _, ok := es.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("redstore: duplicate encryption key id %q", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("redstore: encryption key %q: %w", k.ID, err)
+		}
+		if es.aeads[k.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return es, nil
+}
+
+// ParseEncryptionKeys parses keys formatted as id:base64key.
+func ParseEncryptionKeys(keys []string) ([]EncryptionKey, error) {
+	ret := make([]EncryptionKey, 0, len(keys))
+	for _, k := range keys {
+		id, enc, ok := strings.Cut(strings.TrimSpace(k), ":")
+		if !ok {
+			return nil, fmt.Errorf("redstore: encryption key %q is not formatted as id:base64key", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, fmt.Errorf("redstore: encryption key %q: %w", id, err)
+		}
+		ret = append(ret, EncryptionKey{ID: id, Key: key})
+	}
+	return ret, nil
+}
+
+func (es *EncryptedSerializer) Serialize(s *sessions.Session) ([]byte, error) {
+	plain, err := es.inner.Serialize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := es.aeads[es.primary]
+	header := append(seal(formatEncrypted, nil), byte(len(es.primary)))
+	header = append(header, es.primary...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// Seal must not write over the additional data, so b gets its own array
+	b := make([]byte, 0, len(header)+len(nonce)+len(plain)+aead.Overhead())
+	b = append(append(b, header...), nonce...)
+	return aead.Seal(b, nonce, plain, header), nil
+}
+
+func (es *EncryptedSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	format, payload, err := unseal(d)
+	if err != nil {
+		return err
+	}
+	if format != formatEncrypted {
+		// stored before encryption was enabled
+		return es.inner.Deserialize(d, s)
+	}
+
+	id, rest, err := keyID(payload)
+	if err != nil {
+		return err
+	}
+	aead, ok := es.aeads[id]
+	if !ok {
+		return fmt.Errorf("redstore: unknown encryption key %q", id)
+	}
+	if len(rest) < aead.NonceSize() {
+		return errors.New("redstore: truncated encrypted session")
+	}
+
+	header := d[:len(d)-len(rest)]
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return errors.New("redstore: session decryption failed")
+	}
+	return es.inner.Deserialize(plain, s)
+}
+
+// outdated reports sessions not encrypted with the primary key
+func (es *EncryptedSerializer) outdated(d []byte) bool {
+	format, payload, err := unseal(d)
+	if err != nil {
+		return false
+	}
+	if format != formatEncrypted {
+		return true
+	}
+	id, _, err := keyID(payload)
+	return err == nil && id != es.primary
+}
+
+func keyID(payload []byte) (string, []byte, error) {
+	if len(payload) == 0 || len(payload) < 1+int(payload[0]) {
+		return "", nil, errors.New("redstore: truncated encrypted session")
+	}
+	n := 1 + int(payload[0])
+	return string(payload[1:n]), payload[n:], nil
+}
@@ -0,0 +1,278 @@
+package redstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// indexedKey holds the user a session is indexed for in its values
+	indexedKey = "_indexed"
+	handleLen  = 16
+	noExpiry   = "+inf"
+)
+
+// KEYS: user index, meta hash
+// ARGV: now (ms), session id, expires (ms or +inf), meta (json or empty to
+// only update last seen)
+var indexAdd = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local meta = ARGV[4]
+if meta == '' then
+	local cur = redis.call('HGET', KEYS[2], ARGV[2])
+	if not cur then
+		return 0
+	end
+	local m = cjson.decode(cur)
+	m['seen'] = math.floor(now / 1000)
+	meta = cjson.encode(m)
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[2], meta)
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now)
+for _, id in ipairs(expired) do
+	redis.call('HDEL', KEYS[2], id)
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #last == 0 then
+	redis.call('DEL', KEYS[2])
+	return 1
+end
+if string.lower(last[2]) == 'inf' then
+	redis.call('PERSIST', KEYS[1])
+	redis.call('PERSIST', KEYS[2])
+else
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+	redis.call('PEXPIREAT', KEYS[2], last[2])
+end
+return 1
+`)
+
+// SessionInfo describes a session of a user. Handle identifies the session
+// without revealing its ID.
+type SessionInfo struct {
+	Handle    string    `json:"handle"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expires   time.Time `json:"expires"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+type sessionMeta struct {
+	Created int64  `json:"created"`
+	Seen    int64  `json:"seen"`
+	IP      string `json:"ip"`
+	UA      string `json:"ua"`
+}
+
+// Handle returns the handle of a session ID as used in SessionInfo.
+func Handle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:handleLen])
+}
+
+// UserKey enables the per user session index. Sessions with a value under
+// key (e.g. the user ID set at login) are listed by UserSessions and can be
+// revoked via Revoke and RevokeAll.
+func (s *settings) UserKey(key string) {
+	s.userKey = key
+}
+
+// UserSessions lists the active sessions of user.
+func (s *RedisStore) UserSessions(ctx context.Context, user string) ([]SessionInfo, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	zs, err := s.client.ZRangeByScoreWithScores(ctx, s.userIndexKey(user), &redis.ZRangeBy{Min: "(" + now, Max: noExpiry}).Result()
+	if err != nil || len(zs) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(zs))
+	for _, z := range zs {
+		ids = append(ids, z.Member.(string))
+	}
+	metas, err := s.client.HMGet(ctx, s.userMetaKey(user), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SessionInfo, 0, len(zs))
+	for i, z := range zs {
+		var meta sessionMeta
+		if raw, ok := metas[i].(string); ok {
+			_ = json.Unmarshal([]byte(raw), &meta)
+		}
+		info := SessionInfo{
+			Handle:    Handle(ids[i]),
+			Created:   time.Unix(meta.Created, 0),
+			LastSeen:  time.Unix(meta.Seen, 0),
+			IP:        meta.IP,
+			UserAgent: meta.UA,
+		}
+		if !math.IsInf(z.Score, 1) {
+			info.Expires = time.UnixMilli(int64(z.Score))
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Revoke deletes the session of user identified by handle.
+func (s *RedisStore) Revoke(ctx context.Context, user, handle string) error {
+	ids, err := s.client.ZRange(ctx, s.userIndexKey(user), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if Handle(id) == handle {
+			return s.revoke(ctx, user, id)
+		}
+	}
+	return nil
+}
+
+// RevokeAll deletes all sessions of user except the sessions with the IDs in
+// keep, e.g. to log out all other devices.
+func (s *RedisStore) RevokeAll(ctx context.Context, user string, keep ...string) error {
+	ids, err := s.client.ZRange(ctx, s.userIndexKey(user), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	var revoke []string
+	for _, id := range ids {
+		if !slices.Contains(keep, id) {
+			revoke = append(revoke, id)
+		}
+	}
+	return s.revoke(ctx, user, revoke...)
+}
+
+func (s *RedisStore) revoke(ctx context.Context, user string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	members := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, s.keyPrefix+id)
+		members = append(members, id)
+	}
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, keys...)
+		p.ZRem(ctx, s.userIndexKey(user), members...)
+		p.HDel(ctx, s.userMetaKey(user), ids...)
+		return nil
+	})
+	return err
+}
+
+// indexUser stores the user of session in its values and returns the user
+// it was indexed for so far
+func (s *settings) indexUser(session *sessions.Session) (string, string) {
+	prev, _ := session.Values[indexedKey].(string)
+	if s.userKey == "" {
+		return prev, ""
+	}
+	user := ""
+	if v, ok := session.Values[s.userKey]; ok && v != nil {
+		user = fmt.Sprint(v)
+	}
+	if user == "" {
+		delete(session.Values, indexedKey)
+	} else {
+		session.Values[indexedKey] = user
+	}
+	return prev, user
+}
+
+// index adds session to the index of user; meta is only updated with a
+// request
+func (s *RedisStore) index(ctx context.Context, p redis.Scripter, user, id string, session *sessions.Session, r *http.Request) error {
+	expires := noExpiry
+	if ttl := s.ttl(session); ttl > 0 {
+		expires = strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)
+	}
+
+	meta := ""
+	if r != nil {
+		t, _ := created(session)
+		b, err := json.Marshal(&sessionMeta{
+			Created: t.Unix(),
+			Seen:    time.Now().Unix(),
+			IP:      clientIP(r),
+			UA:      r.UserAgent(),
+		})
+		if err != nil {
+			return err
+		}
+		meta = string(b)
+	}
+
+	keys := []string{s.userIndexKey(user), s.userMetaKey(user)}
+	return indexAdd.Eval(ctx, p, keys, time.Now().UnixMilli(), id, expires, meta).Err()
+}
+
+// reindex moves session from the index of prev to the one of user
+func (s *RedisStore) reindex(ctx context.Context, session *sessions.Session, prev, user string, r *http.Request) error {
+	if prev == "" && user == "" {
+		return nil
+	}
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		if prev != "" && prev != user {
+			s.unindex(ctx, p, prev, session.ID)
+		}
+		if user != "" {
+			return s.index(ctx, p, user, session.ID, session, r)
+		}
+		return nil
+	})
+	return err
+}
+
+// unindex removes a session ID from the index of user
+func (s *RedisStore) unindex(ctx context.Context, p redis.Cmdable, user, id string) {
+	p.ZRem(ctx, s.userIndexKey(user), id)
+	p.HDel(ctx, s.userMetaKey(user), id)
+}
+
+func (s *RedisStore) userIndexKey(user string) string {
+	return s.keyPrefix + "user:" + user
+}
+
+func (s *RedisStore) userMetaKey(user string) string {
+	return s.keyPrefix + "meta:" + user
+}
+
+type clientIPKey struct{}
+
+// WithClientIP returns a shallow copy of r carrying the client IP recorded
+// with sessions saved for it. Forwarding headers are not evaluated by the
+// store, as only the application knows which proxies to trust.
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// clientIP returns the IP set by WithClientIP, the remote address otherwise
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
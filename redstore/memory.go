@@ -0,0 +1,311 @@
+package redstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// MemoryStore keeps sessions in process memory with the same options, key
+// generation, serialization and expiry as RedisStore. It is meant for tests
+// and local development: sessions are lost on restart and not shared between
+// processes.
+type MemoryStore struct {
+	settings
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	id      string
+	data    []byte
+	expires time.Time
+	user    string
+	meta    sessionMeta
+	// replaced by Regenerate, readable until it expires
+	superseded bool
+}
+
+// NewMemoryStore returns a new MemoryStore with default configuration
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		settings: newSettings(),
+		entries:  map[string]*memoryEntry{},
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *MemoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (s *MemoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, id := s.newSession(s, r, name)
+	if id == "" {
+		return session, nil
+	}
+	session.ID = id
+
+	found, err := s.load(session)
+	session.IsNew = !found
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (s *MemoryStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		s.delete(session.ID)
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := s.keyGen()
+		if err != nil {
+			return errors.New("memorystore: failed to generate session id")
+		}
+		session.ID = id
+	}
+	value, err := s.cookieValue(session.Name(), session.ID)
+	if err != nil {
+		return err
+	}
+	s.indexUser(session)
+	if err = s.save(session, r); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), value, session.Options))
+	return nil
+}
+
+// Regenerate moves session to a new ID like RedisStore.Regenerate.
+func (s *MemoryStore) Regenerate(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		return s.Save(r, w, session)
+	}
+
+	id, err := s.keyGen()
+	if err != nil {
+		return errors.New("memorystore: failed to generate session id")
+	}
+	value, err := s.cookieValue(session.Name(), id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if old := s.entries[s.keyPrefix+session.ID]; old != nil {
+		if s.regenerateGrace > 0 {
+			old.user = ""
+			old.superseded = true
+			old.expires = earliest(old.expires, time.Now().Add(s.regenerateGrace))
+		} else {
+			delete(s.entries, s.keyPrefix+session.ID)
+		}
+	}
+	s.mu.Unlock()
+
+	session.ID = id
+	s.indexUser(session)
+	if err = s.save(session, r); err != nil {
+		return err
+	}
+	session.IsNew = false
+	http.SetCookie(w, sessions.NewCookie(session.Name(), value, session.Options))
+	return nil
+}
+
+// Touch restarts the idle timeout of a stored session without rewriting it.
+// Superseded sessions are left to expire.
+func (s *MemoryStore) Touch(_ context.Context, session *sessions.Session) error {
+	if s.idleTimeout <= 0 || session.ID == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.entry(session.ID, time.Now()); e != nil && !e.superseded {
+		e.expires = expiry(s.ttl(session))
+		e.meta.Seen = time.Now().Unix()
+	}
+	return nil
+}
+
+// UserSessions lists the active sessions of user.
+func (s *MemoryStore) UserSessions(_ context.Context, user string) ([]SessionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+
+	var infos []SessionInfo
+	for _, e := range s.entries {
+		if user == "" || e.user != user {
+			continue
+		}
+		infos = append(infos, SessionInfo{
+			Handle:    Handle(e.id),
+			Created:   time.Unix(e.meta.Created, 0),
+			LastSeen:  time.Unix(e.meta.Seen, 0),
+			Expires:   e.expires,
+			IP:        e.meta.IP,
+			UserAgent: e.meta.UA,
+		})
+	}
+	// ordered by expiry like the index of RedisStore
+	slices.SortFunc(infos, func(a, b SessionInfo) int {
+		switch {
+		case a.Expires.Equal(b.Expires):
+			return 0
+		case a.Expires.IsZero():
+			return 1
+		case b.Expires.IsZero():
+			return -1
+		}
+		return a.Expires.Compare(b.Expires)
+	})
+	return infos, nil
+}
+
+// Revoke deletes the session of user identified by handle.
+func (s *MemoryStore) Revoke(_ context.Context, user, handle string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.entries {
+		if user != "" && e.user == user && Handle(e.id) == handle {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// RevokeAll deletes all sessions of user except the sessions with the IDs in
+// keep.
+func (s *MemoryStore) RevokeAll(_ context.Context, user string, keep ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.entries {
+		if user != "" && e.user == user && !slices.Contains(keep, e.id) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// Close drops all sessions
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.entries)
+	return nil
+}
+
+// save stores session; meta is only updated with a request
+func (s *MemoryStore) save(session *sessions.Session, r *http.Request) error {
+	setCreated(session)
+
+	b, err := s.serializer.Serialize(session)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user, _ := session.Values[indexedKey].(string)
+	e := &memoryEntry{id: session.ID, data: b, expires: expiry(s.ttl(session)), user: user}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	if prev := s.entries[s.keyPrefix+session.ID]; prev != nil {
+		if prev.superseded {
+			return ErrSuperseded
+		}
+		e.meta = prev.meta
+	}
+	if r != nil {
+		t, _ := created(session)
+		e.meta = sessionMeta{Created: t.Unix(), IP: clientIP(r), UA: r.UserAgent()}
+	}
+	e.meta.Seen = now.Unix()
+	s.entries[s.keyPrefix+session.ID] = e
+	return nil
+}
+
+// load reads session and reports whether it was found
+func (s *MemoryStore) load(session *sessions.Session) (bool, error) {
+	s.mu.Lock()
+	e := s.entry(session.ID, time.Now())
+	s.mu.Unlock()
+	if e == nil {
+		return false, nil
+	}
+
+	if err := s.serializer.Deserialize(e.data, session); err != nil {
+		return false, err
+	}
+
+	expired, stamped := s.expired(session)
+	if expired {
+		s.delete(session.ID)
+		session.Values = map[interface{}]interface{}{}
+		return false, nil
+	}
+
+	if stamped || outdated(s.serializer, e.data) {
+		// migrate to the current format on read
+		if err := s.save(session, nil); !errors.Is(err, ErrSuperseded) {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, s.keyPrefix+id)
+}
+
+// entry returns the unexpired entry of a session ID; the lock must be held
+func (s *MemoryStore) entry(id string, now time.Time) *memoryEntry {
+	e := s.entries[s.keyPrefix+id]
+	if e != nil && e.expired(now) {
+		delete(s.entries, s.keyPrefix+id)
+		return nil
+	}
+	return e
+}
+
+// sweep deletes expired entries; the lock must be held
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// expiry returns the expiry time of ttl, zero for none
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// earliest returns the earlier of two expiry times, zero for none
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || b.Before(a) {
+		return b
+	}
+	return a
+}
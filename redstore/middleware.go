@@ -53,12 +53,20 @@ func MiddlewareWithConfig(config Config) echo.MiddlewareFunc {
 				return next(c)
 			}
 			defer context.Clear(c.Request())
-			c.Set(key, config.Store)
+			Set(c, config.Store)
 			return next(c)
 		}
 	}
 }
 
+// Set provides store to Get and New for the current request. The client IP
+// recorded with the session is taken from echo's RealIP, which respects the
+// configured IPExtractor.
+func Set(c echo.Context, store sessions.Store) {
+	c.Set(key, store)
+	c.SetRequest(WithClientIP(c.Request(), c.RealIP()))
+}
+
 func getstore(c echo.Context) (sessions.Store, error) {
 	s := c.Get(key)
 	if s == nil {
@@ -3,12 +3,9 @@ package redstore
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/gob"
 	"errors"
-	"math/big"
 	"net/http"
-	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/redis/go-redis/v9"
@@ -18,49 +15,49 @@ const (
 	defaultMaxAge = 86400 * 30
 	defaultPath   = "/"
 	keyPrefix     = "session:"
+	// CreatedKey holds the unix time a session was created at in its values
+	CreatedKey = "_created"
 )
 
+// ErrSuperseded is returned when saving a session whose ID was replaced by
+// Regenerate. Its old key can only be read until the grace period ends.
+var ErrSuperseded = errors.New("redstore: session id was regenerated")
+
+// KEYS: session key, superseded marker
+// ARGV: data, ttl (ms, 0 for none)
+var sessionSave = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// KEYS: session key, superseded marker
+// ARGV: ttl (ms)
+var sessionTouch = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+return redis.call('PEXPIRE', KEYS[1], ARGV[1])
+`)
+
 // RedisStore stores gorilla sessions in Redis
 type RedisStore struct {
+	settings
 	// client to connect to redis
 	client redis.UniversalClient
-	// default options to use when a new session is created
-	options sessions.Options
-	// key prefix with which the session will be stored
-	keyPrefix string
-	// key generator
-	keyGen KeyGenFunc
-	// session serializer
-	serializer SessionSerializer
 }
 
-type KeyGenFunc func() (string, error)
-
 // NewRedisStore returns a new RedisStore with default configuration
 func NewRedisStore(ctx context.Context, client redis.UniversalClient) (*RedisStore, error) {
 	rs := &RedisStore{
-		options: sessions.Options{
-			Path:   defaultPath,
-			MaxAge: defaultMaxAge,
-		},
-		client:    client,
-		keyPrefix: keyPrefix,
-		keyGen: func() (string, error) {
-			const (
-				n       = 64
-				letters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
-			)
-			ret := make([]byte, n)
-			for i := 0; i < n; i++ {
-				num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
-				if err != nil {
-					return "", err
-				}
-				ret[i] = letters[num.Int64()]
-			}
-			return string(ret), nil
-		},
-		serializer: GobSerializer{},
+		settings: newSettings(),
+		client:   client,
 	}
 
 	return rs, rs.client.Ping(ctx).Err()
@@ -74,18 +71,13 @@ func (s *RedisStore) Get(r *http.Request, name string) (*sessions.Session, error
 // New returns a session for the given name without adding it to the registry.
 // nolint: errorlint
 func (s *RedisStore) New(r *http.Request, name string) (*sessions.Session, error) {
-	session := sessions.NewSession(s, name)
-	opts := s.options
-	session.Options = &opts
-	session.IsNew = true
-
-	c, err := r.Cookie(name)
-	if err != nil {
+	session, id := s.newSession(s, r, name)
+	if id == "" {
 		return session, nil
 	}
-	session.ID = c.Value
+	session.ID = id
 
-	err = s.load(r.Context(), session)
+	err := s.load(r.Context(), session)
 	if err == nil {
 		session.IsNew = false
 	} else if err == redis.Nil {
@@ -111,32 +103,90 @@ func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *sessi
 		}
 		session.ID = id
 	}
+	value, err := s.cookieValue(session.Name(), session.ID)
+	if err != nil {
+		return err
+	}
+	prev, user := s.indexUser(session)
 	if err := s.save(r.Context(), session); err != nil {
 		return err
 	}
+	if err := s.reindex(r.Context(), session, prev, user, r); err != nil {
+		return err
+	}
 
-	http.SetCookie(w, sessions.NewCookie(session.Name(), session.ID, session.Options))
+	http.SetCookie(w, sessions.NewCookie(session.Name(), value, session.Options))
 	return nil
 }
 
-// Options set options to use when a new session is created
-func (s *RedisStore) Options(opts sessions.Options) {
-	s.options = opts
-}
+// Regenerate moves session to a new ID, e.g. after login to prevent session
+// fixation, and sets the new cookie. The old key is deleted in the same
+// transaction, or expires after the grace period set via RegenerateGrace so
+// concurrent requests with the old cookie still succeed. Within the grace
+// period the old key is marked as superseded and never extended or rewritten.
+func (s *RedisStore) Regenerate(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" {
+		return s.Save(r, w, session)
+	}
 
-// KeyPrefix sets the key prefix to store session in Redis
-func (s *RedisStore) KeyPrefix(keyPrefix string) {
-	s.keyPrefix = keyPrefix
-}
+	id, err := s.keyGen()
+	if err != nil {
+		return errors.New("redisstore: failed to generate session id")
+	}
+	value, err := s.cookieValue(session.Name(), id)
+	if err != nil {
+		return err
+	}
+	setCreated(session)
+	prev, user := s.indexUser(session)
+	b, err := s.serializer.Serialize(session)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	oldKey := s.keyPrefix + session.ID
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, s.keyPrefix+id, b, s.ttl(session))
+		if s.regenerateGrace > 0 {
+			p.Set(ctx, s.supersededKey(session.ID), 1, s.regenerateGrace)
+			p.ExpireLT(ctx, oldKey, s.regenerateGrace)
+		} else {
+			p.Del(ctx, oldKey)
+		}
+		if prev != "" {
+			s.unindex(ctx, p, prev, session.ID)
+		}
+		if user != "" {
+			return s.index(ctx, p, user, id, session, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-// KeyGen sets the key generator function
-func (s *RedisStore) KeyGen(f KeyGenFunc) {
-	s.keyGen = f
+	session.ID = id
+	session.IsNew = false
+	http.SetCookie(w, sessions.NewCookie(session.Name(), value, session.Options))
+	return nil
 }
 
-// Serializer sets the session serializer to store session
-func (s *RedisStore) Serializer(ss SessionSerializer) {
-	s.serializer = ss
+// Touch restarts the idle timeout of a stored session without rewriting it.
+// Superseded sessions are left to expire.
+func (s *RedisStore) Touch(ctx context.Context, session *sessions.Session) error {
+	if s.idleTimeout <= 0 || session.ID == "" {
+		return nil
+	}
+	keys := []string{s.keyPrefix + session.ID, s.supersededKey(session.ID)}
+	n, err := sessionTouch.Run(ctx, s.client, keys, s.ttl(session).Milliseconds()).Int()
+	if err != nil || n == 0 {
+		return err
+	}
+	if user, _ := session.Values[indexedKey].(string); user != "" {
+		return s.index(ctx, s.client, user, session.ID, session, nil)
+	}
+	return nil
 }
 
 // Close closes the Redis store
@@ -146,12 +196,24 @@ func (s *RedisStore) Close() error {
 
 // save writes session in Redis
 func (s *RedisStore) save(ctx context.Context, session *sessions.Session) error {
+	setCreated(session)
+
 	b, err := s.serializer.Serialize(session)
 	if err != nil {
 		return err
 	}
 
-	return s.client.Set(ctx, s.keyPrefix+session.ID, b, time.Duration(session.Options.MaxAge)*time.Second).Err()
+	keys := []string{s.keyPrefix + session.ID, s.supersededKey(session.ID)}
+	n, err := sessionSave.Run(ctx, s.client, keys, b, s.ttl(session).Milliseconds()).Int()
+	if err == nil && n == 0 {
+		return ErrSuperseded
+	}
+	return err
+}
+
+// supersededKey marks the old key of a regenerated session
+func (s *RedisStore) supersededKey(id string) string {
+	return s.keyPrefix + "superseded:" + id
 }
 
 // load reads session from Redis
@@ -166,12 +228,39 @@ func (s *RedisStore) load(ctx context.Context, session *sessions.Session) error
 		return err
 	}
 
-	return s.serializer.Deserialize(b, session)
+	if err = s.serializer.Deserialize(b, session); err != nil {
+		return err
+	}
+
+	expired, stamped := s.expired(session)
+	if expired {
+		if err = s.delete(ctx, session); err != nil {
+			return err
+		}
+		session.Values = map[interface{}]interface{}{}
+		return redis.Nil
+	}
+
+	if stamped || outdated(s.serializer, b) {
+		// migrate to the current format on read
+		if err = s.save(ctx, session); !errors.Is(err, ErrSuperseded) {
+			return err
+		}
+	}
+	return nil
 }
 
 // delete deletes session in Redis
 func (s *RedisStore) delete(ctx context.Context, session *sessions.Session) error {
-	return s.client.Del(ctx, s.keyPrefix+session.ID).Err()
+	user, _ := session.Values[indexedKey].(string)
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, s.keyPrefix+session.ID)
+		if user != "" {
+			s.unindex(ctx, p, user, session.ID)
+		}
+		return nil
+	})
+	return err
 }
 
 // SessionSerializer provides an interface for serialize/deserialize a session
@@ -180,7 +269,8 @@ type SessionSerializer interface {
 	Deserialize(b []byte, s *sessions.Session) error
 }
 
-// GobSerializer ...
+// GobSerializer stores session values as gob without envelope, readable by
+// older versions. All types stored need to be registered via gob.Register.
 type GobSerializer struct{}
 
 func (gs GobSerializer) Serialize(s *sessions.Session) ([]byte, error) {
@@ -193,7 +283,11 @@ func (gs GobSerializer) Serialize(s *sessions.Session) ([]byte, error) {
 	return nil, err
 }
 
+// Deserialize decodes gob as well as data of the other serializers.
 func (gs GobSerializer) Deserialize(d []byte, s *sessions.Session) error {
-	dec := gob.NewDecoder(bytes.NewBuffer(d))
-	return dec.Decode(&s.Values)
+	return deserialize(d, s)
+}
+
+func (gs GobSerializer) format() byte {
+	return formatGob
 }
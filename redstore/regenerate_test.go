@@ -0,0 +1,100 @@
+package redstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testIdle  = 10 * time.Minute
+	testGrace = 5 * time.Second
+)
+
+// regenerate stores a session, moves it to a new ID and returns the session
+// loaded again with the old cookie
+func regenerate(t *testing.T, store Store) (*sessions.Session, string) {
+	t.Helper()
+	store.IdleTimeout(testIdle)
+	store.RegenerateGrace(testGrace)
+
+	sess, err := store.New(httptest.NewRequest(http.MethodGet, "/", nil), "sid")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sess.Values["user"] = "alice"
+	rec := httptest.NewRecorder()
+	if err = store.Save(httptest.NewRequest(http.MethodGet, "/", nil), rec, sess); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	oldCookie := rec.Result().Cookies()[0]
+	oldID := sess.ID
+
+	if err = store.Regenerate(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), sess); err != nil {
+		t.Fatalf("Regenerate: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(oldCookie)
+	old, err := store.New(req, "sid")
+	if err != nil {
+		t.Fatalf("New with old cookie: %v", err)
+	}
+	if old.IsNew || old.Values["user"] != "alice" {
+		t.Fatal("old session not readable within grace period")
+	}
+	return old, oldID
+}
+
+// expectSuperseded checks that the old session can be neither touched nor
+// saved
+func expectSuperseded(t *testing.T, store Store, old *sessions.Session) {
+	t.Helper()
+	if err := store.Touch(context.Background(), old); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	old.Values["user"] = "mallory"
+	err := store.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), old)
+	if !errors.Is(err, ErrSuperseded) {
+		t.Fatalf("Save of old session: got %v, want ErrSuperseded", err)
+	}
+}
+
+func TestRedisStoreRegenerateGrace(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	store, err := NewRedisStore(context.Background(), rdb)
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+
+	old, oldID := regenerate(t, store)
+	expectSuperseded(t, store, old)
+	if ttl := mr.TTL(keyPrefix + oldID); ttl <= 0 || ttl > testGrace {
+		t.Fatalf("old key TTL: got %s, want at most %s", ttl, testGrace)
+	}
+
+	mr.FastForward(testGrace)
+	if mr.Exists(keyPrefix + oldID) {
+		t.Fatal("old key outlived the grace period")
+	}
+}
+
+func TestMemoryStoreRegenerateGrace(t *testing.T) {
+	store := NewMemoryStore()
+
+	old, oldID := regenerate(t, store)
+	expectSuperseded(t, store, old)
+	e := store.entries[keyPrefix+oldID]
+	if e == nil || time.Until(e.expires) > testGrace {
+		t.Fatalf("old entry not limited to the grace period: %+v", e)
+	}
+}
@@ -0,0 +1,224 @@
+package redstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/gorilla/sessions"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Serialized sessions are wrapped in an envelope of magic bytes, version and
+// format, so serializers can be switched while sessions are live. Data
+// without envelope is legacy gob.
+const (
+	envelopeVersion = 1
+	envelopeLen     = 5
+
+	formatGob     = 'g'
+	formatJSON    = 'j'
+	formatMsgpack = 'm'
+)
+
+var envelopeMagic = []byte{0, 'r', 's'}
+
+// keyTypes lists the supported types of session value keys
+var keyTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, v := range []any{
+		"", false,
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+	} {
+		t := reflect.TypeOf(v)
+		keyTypes[t.String()] = t
+	}
+}
+
+// sessionEntry is a single session value. Key is typed by Type, string keys
+// omit it.
+type sessionEntry struct {
+	Key   any    `json:"k"           msgpack:"k"`
+	Type  string `json:"t,omitempty" msgpack:"t,omitempty"`
+	Value any    `json:"v"           msgpack:"v"`
+}
+
+// JSONSerializer stores session values as JSON, readable without Go. Keys
+// keep their type; values are decoded as JSON types (numbers as float64,
+// objects as map[string]interface{}).
+type JSONSerializer struct{}
+
+func (js JSONSerializer) Serialize(s *sessions.Session) ([]byte, error) {
+	entries, err := toEntries(s.Values)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	return seal(formatJSON, b), nil
+}
+
+func (js JSONSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	return deserialize(d, s)
+}
+
+func (js JSONSerializer) format() byte {
+	return formatJSON
+}
+
+// MsgpackSerializer stores session values as MessagePack. Keys keep their
+// type; values are decoded as generic types (integers as int64 or uint64,
+// maps as map[string]interface{}).
+type MsgpackSerializer struct{}
+
+func (ms MsgpackSerializer) Serialize(s *sessions.Session) ([]byte, error) {
+	entries, err := toEntries(s.Values)
+	if err != nil {
+		return nil, err
+	}
+	b, err := msgpack.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	return seal(formatMsgpack, b), nil
+}
+
+func (ms MsgpackSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	return deserialize(d, s)
+}
+
+func (ms MsgpackSerializer) format() byte {
+	return formatMsgpack
+}
+
+// formatter is implemented by serializers writing a known format
+type formatter interface {
+	format() byte
+}
+
+// outdater is implemented by serializers deciding on their own whether data
+// needs to be rewritten
+type outdater interface {
+	outdated(d []byte) bool
+}
+
+// outdated reports whether d was written in another format than ss writes,
+// so the session should be rewritten
+func outdated(ss SessionSerializer, d []byte) bool {
+	if o, ok := ss.(outdater); ok {
+		return o.outdated(d)
+	}
+	f, ok := ss.(formatter)
+	if !ok {
+		return false
+	}
+	format, _, err := unseal(d)
+	return err == nil && format != f.format()
+}
+
+func seal(format byte, payload []byte) []byte {
+	b := make([]byte, 0, envelopeLen+len(payload))
+	b = append(b, envelopeMagic...)
+	b = append(b, envelopeVersion, format)
+	return append(b, payload...)
+}
+
+func unseal(d []byte) (byte, []byte, error) {
+	if !bytes.HasPrefix(d, envelopeMagic) {
+		return formatGob, d, nil
+	}
+	if len(d) < envelopeLen {
+		return 0, nil, errors.New("redstore: truncated session envelope")
+	}
+	if d[3] != envelopeVersion {
+		return 0, nil, fmt.Errorf("redstore: unsupported session envelope version %d", d[3])
+	}
+	return d[4], d[envelopeLen:], nil
+}
+
+// deserialize decodes d in whatever format it was written
+func deserialize(d []byte, s *sessions.Session) error {
+	format, payload, err := unseal(d)
+	if err != nil {
+		return err
+	}
+
+	var entries []sessionEntry
+	switch format {
+	case formatGob:
+		return gob.NewDecoder(bytes.NewBuffer(payload)).Decode(&s.Values)
+	case formatJSON:
+		err = json.Unmarshal(payload, &entries)
+	case formatMsgpack:
+		dec := msgpack.NewDecoder(bytes.NewReader(payload))
+		dec.UseLooseInterfaceDecoding(true)
+		err = dec.Decode(&entries)
+	default:
+		return fmt.Errorf("redstore: unknown session format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	values := make(map[interface{}]interface{}, len(entries))
+	for _, e := range entries {
+		k, err := fromKey(e.Type, e.Key)
+		if err != nil {
+			return err
+		}
+		values[k] = e.Value
+	}
+	s.Values = values
+	return nil
+}
+
+func toEntries(values map[interface{}]interface{}) ([]sessionEntry, error) {
+	entries := make([]sessionEntry, 0, len(values))
+	for k, v := range values {
+		t := reflect.TypeOf(k)
+		if t == nil || keyTypes[t.String()] != t {
+			return nil, fmt.Errorf("redstore: unsupported session key type %T", k)
+		}
+		e := sessionEntry{Key: k, Value: v}
+		if t.Kind() != reflect.String {
+			e.Type = t.String()
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func fromKey(typ string, k any) (any, error) {
+	if typ == "" {
+		typ = "string"
+	}
+	t, ok := keyTypes[typ]
+	if !ok {
+		return nil, fmt.Errorf("redstore: unsupported session key type %q", typ)
+	}
+	v := reflect.ValueOf(k)
+	if !v.IsValid() || kindClass(v.Kind()) != kindClass(t.Kind()) {
+		return nil, fmt.Errorf("redstore: session key %v is no %s", k, typ)
+	}
+	return v.Convert(t).Interface(), nil
+}
+
+// kindClass groups kinds which can be converted into each other losslessly
+// enough for keys
+func kindClass(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return k
+}
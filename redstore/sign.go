@@ -0,0 +1,79 @@
+package redstore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const minSigningKeyLen = 32
+
+// SigningKeys enables HMAC-SHA256 signing of the session ID in the cookie.
+// The first key signs, all keys verify, so keys can be rotated by prepending
+// a new one. Cookies with an invalid signature are treated as missing without
+// loading the session, which ends all sessions started before signing was
+// enabled unless AcceptUnsigned is set. Keys need at least 32 bytes; without
+// keys the ID is stored as is.
+func (s *settings) SigningKeys(keys ...[]byte) error {
+	codecs := make([]securecookie.Codec, 0, len(keys))
+	for i, key := range keys {
+		if len(key) < minSigningKeyLen {
+			return fmt.Errorf("redstore: signing key %d is shorter than %d bytes", i, minSigningKeyLen)
+		}
+		sc := securecookie.New(key, nil)
+		// expiry is enforced by the store, the signature only proves origin
+		sc.MaxAge(0)
+		sc.SetSerializer(securecookie.NopEncoder{})
+		codecs = append(codecs, sc)
+	}
+	s.codecs = codecs
+	return nil
+}
+
+// AcceptUnsigned lets cookies failing verification pass as unsigned session
+// IDs, so sessions started before signing was enabled are kept during a
+// migration window. Their cookies are signed on the next response (see
+// UnsignedCookie). Forged IDs are looked up in the store then.
+func (s *settings) AcceptUnsigned(accept bool) {
+	s.acceptUnsigned = accept
+}
+
+// UnsignedCookie reports whether session was loaded from an unsigned cookie
+// accepted via AcceptUnsigned and needs to be saved to sign it.
+func (s *settings) UnsignedCookie(r *http.Request, session *sessions.Session) bool {
+	if len(s.codecs) == 0 || !s.acceptUnsigned || session.ID == "" {
+		return false
+	}
+	c, err := r.Cookie(session.Name())
+	// signed values never equal the plain ID
+	return err == nil && c.Value == session.ID
+}
+
+// cookieValue returns the cookie value for the session ID
+func (s *settings) cookieValue(name, id string) (string, error) {
+	if len(s.codecs) == 0 {
+		return id, nil
+	}
+	return securecookie.EncodeMulti(name, []byte(id), s.codecs...)
+}
+
+// cookieID returns the session ID of a cookie value
+func (s *settings) cookieID(name, value string) (string, error) {
+	if len(s.codecs) == 0 {
+		return value, nil
+	}
+	var id []byte
+	if err := securecookie.DecodeMulti(name, value, &id, s.codecs...); err != nil {
+		if s.acceptUnsigned && value != "" {
+			return value, nil
+		}
+		return "", err
+	}
+	if len(id) == 0 {
+		return "", errors.New("redstore: empty session id")
+	}
+	return string(id), nil
+}
@@ -0,0 +1,199 @@
+package redstore
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Store is implemented by RedisStore and MemoryStore
+type Store interface {
+	sessions.Store
+	Regenerate(r *http.Request, w http.ResponseWriter, session *sessions.Session) error
+	Touch(ctx context.Context, session *sessions.Session) error
+	UserSessions(ctx context.Context, user string) ([]SessionInfo, error)
+	Revoke(ctx context.Context, user, handle string) error
+	RevokeAll(ctx context.Context, user string, keep ...string) error
+	Close() error
+
+	Options(opts sessions.Options)
+	DefaultOptions() sessions.Options
+	KeyPrefix(keyPrefix string)
+	KeyGen(f KeyGenFunc)
+	Serializer(ss SessionSerializer)
+	IdleTimeout(d time.Duration)
+	MaxLifetime(d time.Duration)
+	RegenerateGrace(d time.Duration)
+	UserKey(key string)
+	SigningKeys(keys ...[]byte) error
+	AcceptUnsigned(accept bool)
+	UnsignedCookie(r *http.Request, session *sessions.Session) bool
+}
+
+// settings are shared by all stores
+type settings struct {
+	// default options to use when a new session is created
+	options sessions.Options
+	// key prefix with which the session will be stored
+	keyPrefix string
+	// key generator
+	keyGen KeyGenFunc
+	// session serializer
+	serializer SessionSerializer
+	// sessions expire after being idle that long
+	idleTimeout time.Duration
+	// sessions expire that long after creation regardless of activity
+	maxLifetime time.Duration
+	// old session keys remain that long after Regenerate
+	regenerateGrace time.Duration
+	// values key of the user sessions are indexed for
+	userKey string
+	// codecs signing the session ID in the cookie
+	codecs []securecookie.Codec
+	// unsigned cookies pass while migrating to signed ones
+	acceptUnsigned bool
+}
+
+type KeyGenFunc func() (string, error)
+
+func newSettings() settings {
+	return settings{
+		options: sessions.Options{
+			Path:   defaultPath,
+			MaxAge: defaultMaxAge,
+		},
+		keyPrefix:  keyPrefix,
+		keyGen:     generateKey,
+		serializer: GobSerializer{},
+	}
+}
+
+func generateKey() (string, error) {
+	const (
+		n       = 64
+		letters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
+	)
+	ret := make([]byte, n)
+	for i := 0; i < n; i++ {
+		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			return "", err
+		}
+		ret[i] = letters[num.Int64()]
+	}
+	return string(ret), nil
+}
+
+// Options set options to use when a new session is created
+func (s *settings) Options(opts sessions.Options) {
+	s.options = opts
+}
+
+// DefaultOptions returns a copy of the options new sessions are created with
+func (s *settings) DefaultOptions() sessions.Options {
+	return s.options
+}
+
+// KeyPrefix sets the key prefix to store session
+func (s *settings) KeyPrefix(keyPrefix string) {
+	s.keyPrefix = keyPrefix
+}
+
+// KeyGen sets the key generator function
+func (s *settings) KeyGen(f KeyGenFunc) {
+	s.keyGen = f
+}
+
+// Serializer sets the session serializer to store session
+func (s *settings) Serializer(ss SessionSerializer) {
+	s.serializer = ss
+}
+
+// IdleTimeout sets the duration after which unused sessions expire. Each save
+// or Touch restarts it.
+func (s *settings) IdleTimeout(d time.Duration) {
+	s.idleTimeout = d
+}
+
+// MaxLifetime sets the absolute lifetime of sessions, no matter how active.
+func (s *settings) MaxLifetime(d time.Duration) {
+	s.maxLifetime = d
+}
+
+// RegenerateGrace sets how long the old key remains after Regenerate.
+func (s *settings) RegenerateGrace(d time.Duration) {
+	s.regenerateGrace = d
+}
+
+// newSession returns a new session for store and the session ID of the
+// request cookie, which is empty if there is none or its signature is invalid
+func (s *settings) newSession(store sessions.Store, r *http.Request, name string) (*sessions.Session, string) {
+	session := sessions.NewSession(store, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, ""
+	}
+	id, err := s.cookieID(name, c.Value)
+	if err != nil {
+		return session, "" // tampered or signed with a removed key
+	}
+	return session, id
+}
+
+// ttl returns the shortest of cookie max age, idle timeout and the time left
+// until the max lifetime is reached
+func (s *settings) ttl(session *sessions.Session) time.Duration {
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	if s.idleTimeout > 0 && (ttl <= 0 || s.idleTimeout < ttl) {
+		ttl = s.idleTimeout
+	}
+	if t, ok := created(session); ok && s.maxLifetime > 0 {
+		left := max(time.Until(t.Add(s.maxLifetime)), time.Millisecond)
+		if ttl <= 0 || left < ttl {
+			ttl = left
+		}
+	}
+	return ttl
+}
+
+// expired reports whether a loaded session exceeded the max lifetime and
+// whether the creation time was missing. Sessions stored without creation time
+// count from now and need to be written back to keep that time.
+func (s *settings) expired(session *sessions.Session) (bool, bool) {
+	if setCreated(session) {
+		return false, true
+	}
+	t, _ := created(session)
+	return s.maxLifetime > 0 && time.Since(t) >= s.maxLifetime, false
+}
+
+// setCreated stores the creation time in the session values unless present
+func setCreated(session *sessions.Session) bool {
+	if _, ok := created(session); ok {
+		return false
+	}
+	session.Values[CreatedKey] = time.Now().Unix()
+	return true
+}
+
+// created returns the creation time stored in the session values
+func created(session *sessions.Session) (time.Time, bool) {
+	switch v := session.Values[CreatedKey].(type) {
+	case int64:
+		return time.Unix(v, 0), true
+	case int:
+		return time.Unix(int64(v), 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,130 @@
+package echocore
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"sync/atomic"
+)
+
+// MiddlewareBuilder creates a middleware from the given config. It is called
+// again whenever the config gets reloaded.
+type MiddlewareBuilder func(cfg *Config) echo.MiddlewareFunc
+
+type reloadable struct {
+	build MiddlewareBuilder
+	mw    atomic.Pointer[echo.MiddlewareFunc]
+}
+
+func (r *reloadable) apply(cfg *Config) {
+	mw := r.build(cfg)
+	r.mw.Store(&mw)
+}
+
+// LiveConfig returns the config including all changes applied by Reload.
+// Core.Config always holds the config the service was started with.
+func (c *Core) LiveConfig() *Config {
+	return c.liveApp().Base()
+}
+
+// LiveAppConfig is the counterpart of LiveConfig for the application config
+// created by NewCoreWith.
+func LiveAppConfig[T any](c *Core) *T {
+	return any(c.liveApp()).(*T)
+}
+
+func (c *Core) liveApp() Configurer {
+	if app, ok := c.live.Load().(Configurer); ok {
+		return app
+	}
+	if c.app != nil {
+		return c.app
+	}
+	return c.Config
+}
+
+// Reloadable returns a middleware which is rebuilt from the live config on
+// every successful Reload.
+func (c *Core) Reloadable(build MiddlewareBuilder) echo.MiddlewareFunc {
+	r := &reloadable{build: build}
+	r.apply(c.LiveConfig())
+
+	c.mu.Lock()
+	c.reloadables = append(c.reloadables, r)
+	c.mu.Unlock()
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			return (*r.mw.Load())(next)(ctx)
+		}
+	}
+}
+
+func (c *Core) LoggerMiddleware() echo.MiddlewareFunc {
+	return c.Reloadable(func(cfg *Config) echo.MiddlewareFunc {
+		return LoggerMiddleware(cfg.Log.TimeFormat, cfg.Log.LineFormat, cfg.Log.SkipRoutes)
+	})
+}
+
+func (c *Core) GzipMiddleware() echo.MiddlewareFunc {
+	return c.Reloadable(func(cfg *Config) echo.MiddlewareFunc {
+		return GzipMiddleware(cfg.App.GzipCompr)
+	})
+}
+
+func (c *Core) ServerHeaderMiddleware() echo.MiddlewareFunc {
+	return c.Reloadable(func(cfg *Config) echo.MiddlewareFunc {
+		return ServerHeaderMiddleware(cfg.App.ServerHeader)
+	})
+}
+
+// Reload re-reads .env and the environment into a fresh config and validates
+// it. Changes to fields tagged `reload:"true"` are applied to the live config
+// and all reloadable middlewares; changes to any other field are rejected and
+// logged since they require a restart.
+func (c *Core) Reload(e *echo.Echo) error {
+
+	live := c.liveApp()
+	fresh := reflect.New(configType(live))
+	if err := reloadConfig(fresh.Interface()); err != nil {
+		return err
+	}
+	if err := NewValidator().Validate(fresh.Interface()); err != nil {
+		return err
+	}
+
+	next := reflect.New(configType(live))
+	next.Elem().Set(reflect.ValueOf(live).Elem())
+	cur := next.Elem()
+	src := fresh.Elem()
+
+	walkConfig(cur, nil, func(path []reflect.StructField, v reflect.Value) {
+		nv := src.FieldByIndex(fieldIndex(path))
+		if reflect.DeepEqual(v.Interface(), nv.Interface()) {
+			return
+		}
+		if !isReloadable(path) {
+			logrus.Warnf("[Reload] %s changed but requires a restart, ignoring", envName(path))
+			return
+		}
+		logrus.Infof("[Reload] %s changed", envName(path))
+		v.Set(nv)
+	})
+
+	app := next.Interface().(Configurer)
+	c.live.Store(app)
+
+	cfg := app.Base()
+	logrus.SetLevel(cfg.LogrusLevel())
+	if e != nil {
+		e.Logger.SetLevel(cfg.GommonLevel())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.reloadables {
+		r.apply(cfg)
+	}
+
+	return nil
+}
@@ -0,0 +1,399 @@
+package echocore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CtxCacheTags = "cache_tags"
+
+	HeaderXCache = "X-Cache"
+	HeaderAge    = "Age"
+
+	cacheHit  = "HIT"
+	cacheMiss = "MISS"
+)
+
+// KEYS: tag set
+// ARGV: cache key, ttl (ms)
+var respCacheTag = redis.NewScript(`
+redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// response headers never replayed; the encoding is handled by outer
+// middlewares like GzipMiddleware for every response
+var respCacheSkipHeaders = []string{
+	echo.HeaderContentEncoding,
+	echo.HeaderContentLength,
+	echo.HeaderSetCookie,
+	echo.HeaderXRequestID,
+	"Date",
+	HeaderAge,
+	HeaderXCache,
+}
+
+type ResponseCacheConfig struct {
+	Skipper middleware.Skipper
+	// TTL overrides HTTP_CACHE_TTL for responses without max-age.
+	TTL time.Duration
+	// QueryParams being part of the cache key. All query params are used if
+	// nil.
+	QueryParams []string
+	// Vary lists request headers being part of the cache key. Responses
+	// varying on other request headers are not stored.
+	Vary []string
+	// Credentialed enables caching of requests with an Authorization header
+	// or session cookie. Vary needs to separate the users then.
+	Credentialed bool
+}
+
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+type respCacheCall struct {
+	wg    sync.WaitGroup
+	entry *cachedResponse
+}
+
+// ResponseCacheMiddleware caches GET and HEAD responses in Core.Redis. Only
+// 200 responses without Set-Cookie are stored, and only if they do not vary
+// on request headers missing in Vary. Requests with credentials
+// bypass the cache unless enabled via Credentialed. Cache-Control is honored:
+// requests with no-cache or no-store bypass the cache, responses with
+// no-store or private are not stored and max-age/s-maxage set the TTL.
+// Concurrent misses for the same key are coalesced into a single handler
+// call per instance. Handlers can tag responses via Route.CacheTags for
+// invalidation via Route.InvalidateCache.
+func ResponseCacheMiddleware(core *Core, cfg ResponseCacheConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = middleware.DefaultSkipper
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = time.Duration(core.Config.HTTPCache.TTL) * time.Second
+	}
+
+	var (
+		mu    sync.Mutex
+		calls = map[string]*respCacheCall{}
+	)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if cfg.Skipper(c) || SkipperEventStream(c) || c.IsWebSocket() || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+				return next(c)
+			}
+			if !cfg.Credentialed && hasCredentials(req, core.Config.Session.SessID) {
+				return next(c)
+			}
+
+			reqCC := parseCacheControl(req.Header.Get(echo.HeaderCacheControl))
+			if reqCC.has("no-store") {
+				return next(c)
+			}
+
+			key := core.Config.HTTPCache.KeyPrefix + respCacheKey(c, cfg)
+
+			if !reqCC.has("no-cache") {
+				if entry, err := loadCachedResponse(c, core.Redis, key); err == nil {
+					return replayCachedResponse(c, entry, cacheHit)
+				} else if !errors.Is(err, redis.Nil) {
+					logrus.Warnln("[ResponseCacheMiddleware]", err.Error())
+				}
+			}
+
+			mu.Lock()
+			if call, ok := calls[key]; ok {
+				mu.Unlock()
+				call.wg.Wait()
+				if call.entry != nil {
+					return replayCachedResponse(c, call.entry, cacheHit)
+				}
+				return next(c)
+			}
+			call := &respCacheCall{}
+			call.wg.Add(1)
+			calls[key] = call
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				delete(calls, key)
+				mu.Unlock()
+				call.wg.Done()
+			}()
+
+			// set by outer middlewares, which run on cache hits as well
+			outerVary := varyHeaders(c.Response().Header())
+			c.Response().Header().Set(HeaderXCache, cacheMiss)
+			rec := newResponseRecorder(c.Response().Writer, core.Config.HTTPCache.MaxBody)
+			c.Response().Writer = rec
+			err := next(c)
+			c.Response().Writer = rec.ResponseWriter
+			if err != nil {
+				return err
+			}
+
+			ttl, ok := respCacheTTL(c, rec, cfg.TTL)
+			if !ok || !respCacheKeyedBy(varyHeaders(c.Response().Header()), outerVary, cfg.Vary) {
+				return nil
+			}
+			call.entry = &cachedResponse{
+				Status: c.Response().Status,
+				Header: c.Response().Header().Clone(),
+				Body:   rec.body.Bytes(),
+				Stored: time.Now(),
+			}
+			tags, _ := c.Get(CtxCacheTags).([]string)
+			if err = storeCachedResponse(core, key, call.entry, ttl, tags); err != nil {
+				logrus.Warnln("[ResponseCacheMiddleware]", err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+// CacheTags tags the response for invalidation, see ResponseCacheMiddleware.
+func (r *Route) CacheTags(tags ...string) {
+	existing, _ := r.Ctx.Get(CtxCacheTags).([]string)
+	r.Ctx.Set(CtxCacheTags, append(existing, tags...))
+}
+
+// InvalidateCache drops all cached responses tagged with any of the tags.
+func (r *Route) InvalidateCache(tags ...string) error {
+	return r.Core().InvalidateCache(r.Ctx.Request().Context(), tags...)
+}
+
+// InvalidateCache drops all cached responses tagged with any of the tags.
+func (c *Core) InvalidateCache(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		set := c.Config.HTTPCache.KeyPrefix + "tag:" + tag
+		keys, err := c.Redis.SMembers(ctx, set).Result()
+		if err != nil {
+			return err
+		}
+		if err = c.Redis.Del(ctx, append(keys, set)...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func respCacheKey(c echo.Context, cfg ResponseCacheConfig) string {
+	req := c.Request()
+	query := req.URL.Query()
+
+	var b strings.Builder
+	b.WriteString(req.Method + "\n" + req.URL.Path + "\n")
+
+	params := cfg.QueryParams
+	if params == nil {
+		for k := range query {
+			params = append(params, k)
+		}
+		sort.Strings(params)
+	}
+	for _, p := range params {
+		b.WriteString(p + "=" + strings.Join(query[p], ",") + "&")
+	}
+	b.WriteString("\n")
+	for _, h := range cfg.Vary {
+		b.WriteString(h + ":" + req.Header.Get(h) + "\n")
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasCredentials reports requests with an Authorization header or session
+// cookie, whose responses might be specific to a user
+func hasCredentials(req *http.Request, sessCookie string) bool {
+	if req.Header.Get(echo.HeaderAuthorization) != "" {
+		return true
+	}
+	_, err := req.Cookie(sessCookie)
+	return err == nil
+}
+
+func respCacheTTL(c echo.Context, rec *responseRecorder, ttl time.Duration) (time.Duration, bool) {
+	res := c.Response()
+	if res.Status != http.StatusOK || rec.overflow || len(res.Header().Values(echo.HeaderSetCookie)) > 0 {
+		return 0, false
+	}
+	cc := parseCacheControl(res.Header().Get(echo.HeaderCacheControl))
+	if cc.has("no-store") || cc.has("private") || cc.has("no-cache") {
+		return 0, false
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	return ttl, ttl > 0
+}
+
+// respCacheKeyedBy reports whether all request headers the response varies on
+// are part of the cache key or were added by outer middlewares. "*" varies on
+// anything.
+func respCacheKeyedBy(vary, outer, keyed []string) bool {
+	for _, h := range vary {
+		if h == "*" {
+			return false
+		}
+		if slices.Contains(outer, h) || slices.ContainsFunc(keyed, func(k string) bool { return strings.EqualFold(k, h) }) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// varyHeaders returns the canonical request header names of the Vary
+// response header
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values(echo.HeaderVary) {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+func loadCachedResponse(c echo.Context, rdb redis.UniversalClient, key string) (*cachedResponse, error) {
+	bs, err := rdb.Get(c.Request().Context(), key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	entry := new(cachedResponse)
+	return entry, json.Unmarshal(bs, entry)
+}
+
+func storeCachedResponse(core *Core, key string, entry *cachedResponse, ttl time.Duration, tags []string) error {
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = core.Redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, key, bs, ttl)
+		for _, tag := range tags {
+			respCacheTag.Eval(ctx, p, []string{core.Config.HTTPCache.KeyPrefix + "tag:" + tag}, key, ttl.Milliseconds())
+		}
+		return nil
+	})
+	return err
+}
+
+func replayCachedResponse(c echo.Context, entry *cachedResponse, state string) error {
+	h := c.Response().Header()
+	h.Set(HeaderXCache, state)
+	h.Set(HeaderAge, strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	return writeCachedResponse(c, entry)
+}
+
+func writeCachedResponse(c echo.Context, entry *cachedResponse) error {
+	h := c.Response().Header()
+	for k, v := range entry.Header {
+		if slices.Contains(respCacheSkipHeaders, k) {
+			continue
+		}
+		h[k] = slices.Clone(v)
+	}
+	c.Response().WriteHeader(entry.Status)
+	if c.Request().Method == http.MethodHead {
+		return nil
+	}
+	_, err := c.Response().Write(entry.Body)
+	return err
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(v string) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(v, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if k != "" {
+			cc[strings.ToLower(k)] = strings.Trim(val, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// responseRecorder passes everything through to the wrapped writer while
+// keeping a copy of up to limit bytes of the body.
+type responseRecorder struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func newResponseRecorder(w http.ResponseWriter, limit int) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, limit: limit}
+}
+
+func (r *responseRecorder) Write(bs []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(bs) > r.limit {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(bs)
+		}
+	}
+	return r.ResponseWriter.Write(bs)
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
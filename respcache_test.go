@@ -0,0 +1,151 @@
+package echocore
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRespCache(t *testing.T, cfg ResponseCacheConfig) (*echo.Echo, *Core) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	core := &Core{Config: &Config{}, Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	t.Cleanup(func() { _ = core.Redis.Close() })
+	core.Config.Session.SessID = "sid"
+	core.Config.HTTPCache.KeyPrefix = "httpcache:"
+	core.Config.HTTPCache.TTL = 60
+	core.Config.HTTPCache.MaxBody = 1024
+
+	e := echo.New()
+	e.Use(ContextMiddleware(CtxCore, core), ResponseCacheMiddleware(core, cfg))
+	return e, core
+}
+
+func getCached(e *echo.Echo, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func expectCache(t *testing.T, rec *httptest.ResponseRecorder, state, body string) {
+	t.Helper()
+	if got := rec.Header().Get(HeaderXCache); got != state || rec.Body.String() != body {
+		t.Fatalf("got %s %q, want %s %q", got, rec.Body.String(), state, body)
+	}
+}
+
+func TestResponseCacheHitMiss(t *testing.T) {
+	e, _ := newTestRespCache(t, ResponseCacheConfig{QueryParams: []string{"page"}})
+	var calls atomic.Int32
+	e.GET("/items", func(c echo.Context) error {
+		calls.Add(1)
+		return c.String(http.StatusOK, "page "+c.QueryParam("page"))
+	})
+
+	expectCache(t, getCached(e, "/items?page=1", nil), cacheMiss, "page 1")
+	expectCache(t, getCached(e, "/items?page=1&sort=name", nil), cacheHit, "page 1")
+	expectCache(t, getCached(e, "/items?page=2", nil), cacheMiss, "page 2")
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+
+	noCache := http.Header{echo.HeaderCacheControl: {"no-cache"}}
+	expectCache(t, getCached(e, "/items?page=1", noCache), cacheMiss, "page 1")
+	credentials := http.Header{echo.HeaderAuthorization: {"Bearer token"}}
+	expectCache(t, getCached(e, "/items?page=1", credentials), "", "page 1")
+}
+
+func TestResponseCacheCoalescing(t *testing.T) {
+	e, _ := newTestRespCache(t, ResponseCacheConfig{})
+	var calls atomic.Int32
+	release := make(chan struct{})
+	e.GET("/slow", func(c echo.Context) error {
+		calls.Add(1)
+		<-release
+		return c.String(http.StatusOK, "done")
+	})
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, 5)
+	for i := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = getCached(e, "/slow", nil)
+		}()
+	}
+	// let all requests miss before the first one completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+	var misses int
+	for _, rec := range recs {
+		if rec.Body.String() != "done" {
+			t.Fatalf("got %q, want %q", rec.Body.String(), "done")
+		}
+		if rec.Header().Get(HeaderXCache) == cacheMiss {
+			misses++
+		}
+	}
+	if misses != 1 {
+		t.Fatalf("got %d misses, want 1", misses)
+	}
+}
+
+func TestResponseCacheInvalidateTags(t *testing.T) {
+	e, core := newTestRespCache(t, ResponseCacheConfig{})
+	var calls atomic.Int32
+	e.GET("/users", func(c echo.Context) error {
+		r := NewRoute(c)
+		r.CacheTags("users")
+		return c.String(http.StatusOK, "users "+strconv.Itoa(int(calls.Add(1))))
+	})
+
+	expectCache(t, getCached(e, "/users", nil), cacheMiss, "users 1")
+	expectCache(t, getCached(e, "/users", nil), cacheHit, "users 1")
+	if err := core.InvalidateCache(context.Background(), "orders"); err != nil {
+		t.Fatalf("InvalidateCache: %v", err)
+	}
+	expectCache(t, getCached(e, "/users", nil), cacheHit, "users 1")
+	if err := core.InvalidateCache(context.Background(), "users"); err != nil {
+		t.Fatalf("InvalidateCache: %v", err)
+	}
+	expectCache(t, getCached(e, "/users", nil), cacheMiss, "users 2")
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	e, _ := newTestRespCache(t, ResponseCacheConfig{Vary: []string{"accept-language"}})
+	e.GET("/:vary", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderVary, c.Param("vary"))
+		return c.String(http.StatusOK, c.Request().Header.Get("X-User"))
+	})
+
+	for _, tt := range []struct {
+		vary  string
+		state string
+		body  string
+	}{
+		{vary: "Accept-Language", state: cacheHit, body: "alice"},
+		{vary: "X-User", state: cacheMiss, body: "bob"},
+		{vary: "*", state: cacheMiss, body: "bob"},
+	} {
+		expectCache(t, getCached(e, "/"+tt.vary, http.Header{"X-User": {"alice"}}), cacheMiss, "alice")
+		expectCache(t, getCached(e, "/"+tt.vary, http.Header{"X-User": {"bob"}}), tt.state, tt.body)
+	}
+}
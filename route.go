@@ -1,6 +1,7 @@
 package echocore
 
 import (
+	"encoding/json"
 	"github.com/labstack/echo/v4"
 	"github.com/mrccnt/echocore/redstore"
 	"github.com/redis/go-redis/v9"
@@ -12,6 +13,8 @@ import (
 const (
 	CtxCore    = "core"
 	CtxSession = "session"
+
+	MIMEApplicationProblemJSON = "application/problem+json"
 )
 
 type Handler interface {
@@ -28,6 +31,15 @@ type ServiceMessage struct {
 	Message string `json:"message"`
 }
 
+// Problem is a RFC 9457 problem details response.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
 func NewRoute(ctx echo.Context) Route {
 	return Route{ctx}
 }
@@ -69,20 +81,50 @@ func (r *Route) Bind(i interface{}) error {
 	return nil
 }
 
+func (r *Route) Core() *Core {
+	return r.Ctx.Get(CtxCore).(*Core)
+}
+
 func (r *Route) Config() *Config {
-	return r.Ctx.Get(CtxCore).(*Core).Config
+	return r.Core().Config
 }
 
 func (r *Route) Gorm() *gorm.DB {
-	return r.Ctx.Get(CtxCore).(*Core).Gorm
+	return r.Core().Gorm
 }
 
 func (r *Route) Redis() *redis.Client {
-	return r.Ctx.Get(CtxCore).(*Core).Redis
+	return r.Core().Redis
 }
 
 func (r *Route) SessStore() *redstore.RedisStore {
-	return r.Ctx.Get(CtxCore).(*Core).SessStore
+	return r.Core().SessStore
+}
+
+// Sessions returns the session store selected by SESS_STORE, see
+// Core.Sessions.
+func (r *Route) Sessions() redstore.Store {
+	return r.Core().Sessions()
+}
+
+func (r *Route) Queue() *Queue {
+	return r.Core().Queue
+}
+
+// Enqueue adds a job to Core.Queue, see Queue.Enqueue.
+func (r *Route) Enqueue(jobType string, payload any, opts ...EnqueueOption) (string, error) {
+	return r.Queue().Enqueue(r.Ctx.Request().Context(), jobType, payload, opts...)
+}
+
+// Lock acquires the lock for key, waiting as long as the request lasts. The
+// lock is released at the latest when the request is done.
+func (r *Route) Lock(key string) (*Lock, error) {
+	return r.Core().Locker().Lock(r.Ctx.Request().Context(), key)
+}
+
+// RouteConfig returns the application config, see NewCoreWith.
+func RouteConfig[T any](r *Route) *T {
+	return AppConfig[T](r.Core())
 }
 
 func (r *Route) Error(err error) error {
@@ -94,3 +136,18 @@ func (r *Route) BadRequest(err error) error {
 	logrus.Warnln(err.Error())
 	return r.Ctx.JSON(http.StatusBadRequest, &ServiceMessage{Message: err.Error()})
 }
+
+// Problem responds with a problem details document for the given status.
+func (r *Route) Problem(status int, detail string) error {
+	bs, err := json.Marshal(&Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.Ctx.Request().URL.Path,
+	})
+	if err != nil {
+		return err
+	}
+	return r.Ctx.Blob(status, MIMEApplicationProblemJSON, bs)
+}
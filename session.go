@@ -0,0 +1,217 @@
+package echocore
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/sessions"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	FlashInfo    = "info"
+	FlashSuccess = "success"
+	FlashWarning = "warning"
+	FlashError   = "error"
+
+	flashKeyPrefix = "_flash:"
+)
+
+var ErrNoSession = errors.New("no session loaded")
+
+// Session wraps the session loaded by SessionMiddleware, which also saves
+// changes before the response is written. If no session was loaded (the
+// middleware is missing or the store failed), reads return zero values and
+// writes fail with ErrNoSession.
+type Session struct {
+	sess *sessions.Session
+}
+
+// Flash is a one-time message shown on the next page.
+type Flash struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// Session returns the session of the request.
+func (r *Route) Session() *Session {
+	sess, _ := r.Ctx.Get(CtxSession).(*sessions.Session)
+	return &Session{sess: sess}
+}
+
+// SessionGet returns the value of key as T. Numbers and generic maps or slices
+// as decoded by the JSON and MessagePack serializers are converted to T if
+// possible.
+func SessionGet[T any](s *Session, key string) (T, bool) {
+	var zero T
+	if s.sess == nil {
+		return zero, false
+	}
+	v, ok := s.sess.Values[key]
+	if !ok {
+		return zero, false
+	}
+	return convertSessionValue[T](v)
+}
+
+// Loaded reports whether a session was loaded.
+func (s *Session) Loaded() bool {
+	return s.sess != nil
+}
+
+// Raw returns the underlying session, nil if none was loaded.
+func (s *Session) Raw() *sessions.Session {
+	return s.sess
+}
+
+// ID returns the session ID, empty for new sessions.
+func (s *Session) ID() string {
+	if s.sess == nil {
+		return ""
+	}
+	return s.sess.ID
+}
+
+// IsNew reports whether the session is not stored yet.
+func (s *Session) IsNew() bool {
+	return s.sess == nil || s.sess.IsNew
+}
+
+// Set sets the value of key.
+func (s *Session) Set(key string, value any) error {
+	if s.sess == nil {
+		return ErrNoSession
+	}
+	s.sess.Values[key] = value
+	return nil
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) error {
+	if s.sess == nil {
+		return ErrNoSession
+	}
+	delete(s.sess.Values, key)
+	return nil
+}
+
+// Destroy deletes the session from the store and expires the cookie, e.g. on
+// logout.
+func (s *Session) Destroy() error {
+	if s.sess == nil {
+		return ErrNoSession
+	}
+	s.sess.Values = map[interface{}]interface{}{}
+	if s.sess.Options == nil {
+		// the expired cookie needs the path and domain of the stored one
+		opts := sessions.Options{Path: "/"}
+		if store, ok := s.sess.Store().(interface{ DefaultOptions() sessions.Options }); ok {
+			opts = store.DefaultOptions()
+		}
+		s.sess.Options = &opts
+	}
+	s.sess.Options.MaxAge = -1
+	return nil
+}
+
+// AddFlash adds a message of category (e.g. FlashInfo).
+func (s *Session) AddFlash(category, message string) error {
+	if s.sess == nil {
+		return ErrNoSession
+	}
+	key := flashKeyPrefix + category
+	s.sess.Values[key] = append(flashMessages(s.sess.Values[key]), message)
+	return nil
+}
+
+// Flashes returns and removes the messages of the given categories, of all
+// categories if none are given. Messages are ordered by category and then by
+// the order they were added in.
+func (s *Session) Flashes(categories ...string) []Flash {
+	if s.sess == nil {
+		return nil
+	}
+	if len(categories) == 0 {
+		for k := range s.sess.Values {
+			if key, ok := k.(string); ok && strings.HasPrefix(key, flashKeyPrefix) {
+				categories = append(categories, strings.TrimPrefix(key, flashKeyPrefix))
+			}
+		}
+		sort.Strings(categories)
+	}
+
+	var flashes []Flash
+	for _, category := range categories {
+		key := flashKeyPrefix + category
+		for _, msg := range flashMessages(s.sess.Values[key]) {
+			flashes = append(flashes, Flash{Category: category, Message: msg})
+		}
+		delete(s.sess.Values, key)
+	}
+	return flashes
+}
+
+// flashMessages returns a copy of stored messages, which are []interface{}
+// if decoded by the JSON or MessagePack serializer
+func flashMessages(v any) []string {
+	switch msgs := v.(type) {
+	case []string:
+		return slices.Clone(msgs)
+	case []interface{}:
+		ret := make([]string, 0, len(msgs))
+		for _, msg := range msgs {
+			if s, ok := msg.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func convertSessionValue[T any](v any) (T, bool) {
+	var zero T
+	if t, ok := v.(T); ok {
+		return t, true
+	}
+	if v == nil {
+		return zero, false
+	}
+
+	rv, rt := reflect.ValueOf(v), reflect.TypeFor[T]()
+	switch {
+	case isNumber(rv.Kind()) && isNumber(rt.Kind()):
+		// only if lossless, e.g. float64(3) to int
+		cv := rv.Convert(rt)
+		if cv.Convert(rv.Type()).Interface() != v {
+			return zero, false
+		}
+		return cv.Interface().(T), true
+	case rv.Kind() == rt.Kind() && rv.Type().ConvertibleTo(rt):
+		// named types, e.g. string to Role
+		return rv.Convert(rt).Interface().(T), true
+	case rt.Kind() == reflect.Struct || rt.Kind() == reflect.Map || rt.Kind() == reflect.Slice:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return zero, false
+		}
+		var t T
+		if err = json.Unmarshal(b, &t); err != nil {
+			return zero, false
+		}
+		return t, true
+	}
+	return zero, false
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
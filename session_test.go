@@ -0,0 +1,151 @@
+package echocore
+
+import (
+	"encoding/gob"
+	"github.com/gorilla/sessions"
+	"github.com/mrccnt/echocore/redstore"
+	"reflect"
+	"testing"
+)
+
+type testRole string
+
+type testProfile struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func init() {
+	gob.Register(map[string]interface{}{})
+}
+
+// roundTrip stores v under key and loads it again like a store would
+func roundTrip(t *testing.T, ss redstore.SessionSerializer, key string, v any) *Session {
+	t.Helper()
+	in := sessions.NewSession(nil, "sid")
+	in.Values[key] = v
+	b, err := ss.Serialize(in)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	out := sessions.NewSession(nil, "sid")
+	if err = ss.Deserialize(b, out); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	return &Session{sess: out}
+}
+
+func TestSessionGet(t *testing.T) {
+	serializers := map[string]redstore.SessionSerializer{
+		"gob":     redstore.GobSerializer{},
+		"json":    redstore.JSONSerializer{},
+		"msgpack": redstore.MsgpackSerializer{},
+	}
+	tests := []struct {
+		name   string
+		stored any
+		get    func(s *Session) (any, bool)
+		want   any
+		ok     bool
+	}{
+		{
+			name:   "int to int64",
+			stored: 3,
+			get:    func(s *Session) (any, bool) { return SessionGet[int64](s, "k") },
+			want:   int64(3),
+			ok:     true,
+		},
+		{
+			name:   "float64 to int64",
+			stored: float64(42),
+			get:    func(s *Session) (any, bool) { return SessionGet[int64](s, "k") },
+			want:   int64(42),
+			ok:     true,
+		},
+		{
+			name:   "lossy float64 to int64",
+			stored: 1.5,
+			get:    func(s *Session) (any, bool) { return SessionGet[int64](s, "k") },
+			want:   int64(0),
+			ok:     false,
+		},
+		{
+			name:   "string to named type",
+			stored: "admin",
+			get:    func(s *Session) (any, bool) { return SessionGet[testRole](s, "k") },
+			want:   testRole("admin"),
+			ok:     true,
+		},
+		{
+			name:   "map to struct",
+			stored: map[string]interface{}{"name": "alice", "age": 30},
+			get:    func(s *Session) (any, bool) { return SessionGet[testProfile](s, "k") },
+			want:   testProfile{Name: "alice", Age: 30},
+			ok:     true,
+		},
+		{
+			name:   "string to int",
+			stored: "3",
+			get:    func(s *Session) (any, bool) { return SessionGet[int](s, "k") },
+			want:   0,
+			ok:     false,
+		},
+	}
+
+	for sname, ss := range serializers {
+		for _, tt := range tests {
+			t.Run(sname+"/"+tt.name, func(t *testing.T) {
+				got, ok := tt.get(roundTrip(t, ss, "k", tt.stored))
+				if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %#v, %v; want %#v, %v", got, ok, tt.want, tt.ok)
+				}
+			})
+		}
+	}
+}
+
+func TestSessionGetMissing(t *testing.T) {
+	if v, ok := SessionGet[string](&Session{}, "k"); ok || v != "" {
+		t.Fatalf("without session: got %q, %v", v, ok)
+	}
+	s := &Session{sess: sessions.NewSession(nil, "sid")}
+	if v, ok := SessionGet[string](s, "k"); ok || v != "" {
+		t.Fatalf("missing key: got %q, %v", v, ok)
+	}
+}
+
+func TestSessionWithoutSession(t *testing.T) {
+	s := &Session{}
+	if err := s.Set("k", "v"); err != ErrNoSession {
+		t.Fatalf("Set: got %v, want ErrNoSession", err)
+	}
+	if err := s.Delete("k"); err != ErrNoSession {
+		t.Fatalf("Delete: got %v, want ErrNoSession", err)
+	}
+	if err := s.Destroy(); err != ErrNoSession {
+		t.Fatalf("Destroy: got %v, want ErrNoSession", err)
+	}
+}
+
+func TestSessionDestroyDefaultOptions(t *testing.T) {
+	store := redstore.NewMemoryStore()
+	store.Options(sessions.Options{Path: "/app", Domain: "example.com", MaxAge: 3600})
+	sess := sessions.NewSession(store, "sid")
+	sess.Options = nil
+	sess.Values["k"] = "v"
+
+	s := &Session{sess: sess}
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+	opts := sess.Options
+	if opts == nil || opts.Path != "/app" || opts.Domain != "example.com" || opts.MaxAge != -1 {
+		t.Fatalf("got options %+v, want store defaults with MaxAge -1", opts)
+	}
+	if len(sess.Values) != 0 {
+		t.Fatalf("values not cleared: %v", sess.Values)
+	}
+	if o := store.DefaultOptions(); o.MaxAge != 3600 {
+		t.Fatalf("store defaults changed: %+v", o)
+	}
+}
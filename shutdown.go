@@ -0,0 +1,228 @@
+package echocore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Shutdown hooks run in ascending priority. Hooks registered by applications
+// usually use PriorityDefault so they run after all workers returned. The
+// resources managed by Core (SessStore, Redis, Gorm, TmpDir) are closed after
+// all hooks returned, regardless of their priority.
+const (
+	PriorityWorkers = -1000
+	PriorityDefault = 0
+)
+
+// shutdownGrace bounds waiting for hooks and workers still running once the
+// shutdown deadline passed
+const shutdownGrace = 5 * time.Second
+
+var errStillRunning = errors.New("still running after shutdown deadline")
+
+// ShutdownFunc is called during shutdown. It must return once ctx is done.
+type ShutdownFunc func(ctx context.Context) error
+
+type shutdownHook struct {
+	name     string
+	priority int
+	timeout  time.Duration
+	fn       ShutdownFunc
+}
+
+// OnShutdown registers a hook called by Shutdown. Hooks with equal priority
+// run in registration order. A timeout of 0 lets the hook use whatever is
+// left of the hook budget (App.EchoTimeout).
+func (c *Core) OnShutdown(name string, priority int, timeout time.Duration, fn ShutdownFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, shutdownHook{name: name, priority: priority, timeout: timeout, fn: fn})
+}
+
+// Shutdown runs all shutdown hooks within the App.EchoTimeout budget and
+// closes the resources managed by Core.
+func (c *Core) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout())
+	defer cancel()
+	return c.ShutdownContext(ctx)
+}
+
+// ShutdownContext cancels the Core context and runs all shutdown hooks within
+// ctx. Hooks and workers exceeding their deadline are waited for up to
+// shutdownGrace longer, so usually none is still running when the resources
+// managed by Core are closed afterwards. Those running even longer are
+// reported as errors and the resources are closed anyway.
+// Every hook is called even if a previous one failed; all errors are collected
+// and returned joined.
+func (c *Core) ShutdownContext(ctx context.Context) error {
+
+	c.mu.Lock()
+	// under lock, so Worker can not start workers anymore
+	c.cancelContext()
+	hooks := slices.Clone(c.hooks)
+	c.hooks = nil
+	c.mu.Unlock()
+
+	grace, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(shutdownGrace, cancel)
+	})
+	defer stop()
+
+	slices.SortStableFunc(hooks, func(a, b shutdownHook) int {
+		return a.priority - b.priority
+	})
+
+	var errs []error
+	for _, h := range hooks {
+		if err := h.run(ctx, grace); err != nil {
+			logrus.Errorf("[Shutdown] [%s] %s", h.name, err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+	// workers exceeding the budget might still use the resources
+	if !waitGroup(grace, &c.workerWg) {
+		logrus.Errorf("[Shutdown] [Workers] %s", errStillRunning.Error())
+		errs = append(errs, fmt.Errorf("Workers: %w", errStillRunning))
+	}
+
+	for _, r := range c.resources() {
+		if err := r.close(); err != nil {
+			logrus.Errorf("[Shutdown] [%s] %s", r.name, err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run calls the hook within ctx and waits for it to return until grace is done
+func (h shutdownHook) run(ctx, grace context.Context) error {
+
+	logrus.Debugf("[Shutdown] [%s] Running", h.name)
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- h.fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// hooks must return once ctx is done; wait for them a little longer so
+	// they do not run concurrently with later hooks or the teardown of
+	// resources
+	logrus.Warnf("[Shutdown] [%s] %s, waiting for hook to return", h.name, ctx.Err().Error())
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, ctx.Err()) {
+			return errors.Join(ctx.Err(), err)
+		}
+		return ctx.Err()
+	case <-grace.Done():
+		return errStillRunning
+	}
+}
+
+// waitGroup waits for wg and reports whether it finished before ctx was done
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type resource struct {
+	name  string
+	close func() error
+}
+
+// resources lists the resources managed by Core in the order they are closed
+func (c *Core) resources() []resource {
+	return []resource{
+		{name: "SessStore", close: c.closeSessStore},
+		{name: "Redis", close: c.closeRedis},
+		{name: "Gorm", close: c.closeGorm},
+		{name: "TmpDir", close: c.removeTmpDir},
+	}
+}
+
+func (c *Core) closeSessStore() error {
+	store := c.Sessions()
+	if store == nil {
+		return nil
+	}
+	logDown(store, "Close")
+	err := store.Close()
+	c.SetSessions(nil)
+	return err
+}
+
+func (c *Core) closeRedis() error {
+	if c.Redis == nil {
+		return nil
+	}
+	logDown(c.Redis, "Close")
+	err := c.Redis.Close()
+	c.Redis = nil
+	if errors.Is(err, redis.ErrClosed) {
+		// already closed by SessStore which shares the client
+		return nil
+	}
+	return err
+}
+
+func (c *Core) closeGorm() error {
+	if c.Gorm == nil {
+		return nil
+	}
+	logDown(c.Gorm, "Close")
+	db, err := c.Gorm.DB()
+	c.Gorm = nil
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func (c *Core) removeTmpDir() error {
+	if c.TmpDir == "" {
+		return nil
+	}
+	logDown("TmpDir", "TmpDir Cleanup")
+	err := os.RemoveAll(c.TmpDir)
+	c.TmpDir = ""
+	return err
+}
+
+func (c *Core) shutdownTimeout() time.Duration {
+	return time.Duration(c.Config.App.EchoTimeout) * time.Second
+}
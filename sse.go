@@ -0,0 +1,244 @@
+package echocore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MIMETextEventStream = "text/event-stream"
+	HeaderLastEventID   = "Last-Event-ID"
+
+	sseStreamBlock = 2 * time.Second
+	sseStreamCount = 100
+	sseFieldEvent  = "event"
+	sseFieldData   = "data"
+)
+
+// SSEEvent is a single server-sent event. Data is written as is if it is a
+// string or []byte and JSON encoded otherwise.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  any
+}
+
+// ErrSSEClosed is returned by writes after the stream handler returned.
+var ErrSSEClosed = errors.New("event stream closed")
+
+// SSE is an open event stream created by Route.SSE.
+type SSE struct {
+	route  *Route
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   func() bool
+	mu     sync.Mutex
+	err    error
+	done   chan struct{}
+}
+
+// SkipperEventStream skips requests accepting text/event-stream, e.g. for
+// middlewares buffering responses.
+func SkipperEventStream(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), MIMETextEventStream)
+}
+
+// SSE starts an event stream response and runs fn with it. A retry hint
+// (SSE_RETRY_MS) is sent right away and heartbeats every SSE_HEARTBEAT
+// seconds keep the connection open until fn returns. The stream context is
+// done once the client disconnects or the shutdown begins; fn should return
+// then.
+func (r *Route) SSE(fn func(s *SSE) error) error {
+	cfg := r.Config().SSE
+
+	s := &SSE{route: r, done: make(chan struct{})}
+	s.ctx, s.cancel = context.WithCancel(r.Ctx.Request().Context())
+	s.stop = context.AfterFunc(r.Core().Context(), s.cancel)
+
+	h := r.Ctx.Response().Header()
+	h.Set(echo.HeaderContentType, MIMETextEventStream)
+	h.Set(echo.HeaderCacheControl, "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	r.Ctx.Response().WriteHeader(http.StatusOK)
+
+	if err := s.write("retry: " + strconv.Itoa(cfg.RetryMs) + "\n\n"); err != nil {
+		s.stop()
+		s.cancel()
+		return err
+	}
+	go s.heartbeat(time.Duration(cfg.Heartbeat) * time.Second)
+	// nothing may be written once the handler returned
+	defer s.close()
+	return fn(s)
+}
+
+// Context is done once the client disconnects or the shutdown begins.
+func (s *SSE) Context() context.Context {
+	return s.ctx
+}
+
+// LastEventID returns the id of the last event the client received before
+// reconnecting.
+func (s *SSE) LastEventID() string {
+	return s.route.Ctx.Request().Header.Get(HeaderLastEventID)
+}
+
+// Send writes an event and flushes it to the client.
+func (s *SSE) Send(ev SSEEvent) error {
+	data, err := sseData(ev.Data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Stream sends all events of the Redis stream published via PublishSSE,
+// starting after Last-Event-ID or with new events if the client did not
+// send a valid one. It blocks until the stream context is done.
+func (s *SSE) Stream(stream string) error {
+	rdb := s.route.Redis()
+	key := s.route.Config().SSE.KeyPrefix + stream
+
+	// "$" can not be used for XREAD in a loop without missing events
+	// published in between
+	id := s.LastEventID()
+	if !isStreamID(id) {
+		last, err := rdb.XRevRangeN(s.ctx, key, "+", "-", 1).Result()
+		if err != nil {
+			return err
+		}
+		id = "0-0"
+		if len(last) > 0 {
+			id = last[0].ID
+		}
+	}
+
+	for {
+		res, err := rdb.XRead(s.ctx, &redis.XReadArgs{
+			Streams: []string{key, id},
+			Count:   sseStreamCount,
+			Block:   sseStreamBlock,
+		}).Result()
+		if s.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for _, xs := range res {
+			for _, msg := range xs.Messages {
+				event, _ := msg.Values[sseFieldEvent].(string)
+				data, _ := msg.Values[sseFieldData].(string)
+				if err = s.Send(SSEEvent{ID: msg.ID, Event: event, Data: data}); err != nil {
+					return err
+				}
+				id = msg.ID
+			}
+		}
+	}
+}
+
+// isStreamID reports whether id is a Redis stream ID (<ms>-<seq>), which XREAD
+// accepts. The header is sent by clients, so it might be anything.
+func isStreamID(id string) bool {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return false
+	}
+	_, errMs := strconv.ParseUint(ms, 10, 64)
+	_, errSeq := strconv.ParseUint(seq, 10, 64)
+	return errMs == nil && errSeq == nil
+}
+
+// close stops the heartbeats and fails further writes
+func (s *SSE) close() {
+	s.stop()
+	s.cancel()
+	<-s.done
+	s.mu.Lock()
+	s.err = ErrSSEClosed
+	s.mu.Unlock()
+}
+
+func (s *SSE) heartbeat(interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		<-s.ctx.Done()
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *SSE) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	res := s.route.Ctx.Response()
+	if _, s.err = res.Write([]byte(msg)); s.err == nil {
+		s.err = http.NewResponseController(res.Writer).Flush()
+	}
+	if s.err != nil {
+		s.cancel()
+	}
+	return s.err
+}
+
+// PublishSSE appends an event to a Redis stream read by SSE.Stream. The
+// stream is capped to about SSE_STREAM_MAX_LEN events. It returns the event
+// id.
+func (c *Core) PublishSSE(ctx context.Context, stream, event string, data any) (string, error) {
+	payload, err := sseData(data)
+	if err != nil {
+		return "", err
+	}
+	return c.Redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: c.Config.SSE.KeyPrefix + stream,
+		MaxLen: int64(c.Config.SSE.StreamMaxLen),
+		Approx: true,
+		Values: []string{sseFieldEvent, event, sseFieldData, payload},
+	}).Result()
+}
+
+func sseData(data any) (string, error) {
+	switch v := data.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	bs, err := json.Marshal(data)
+	return string(bs), err
+}
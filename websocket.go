@@ -0,0 +1,357 @@
+package echocore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/gorilla/sessions"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const wsConnIDLen = 8
+
+var ErrWSSlowConsumer = errors.New("websocket send buffer full")
+
+// WSConfig configures a WebSocket endpoint created by Hub.Handler.
+type WSConfig struct {
+	// Authorize is called before the upgrade with the session loaded by
+	// SessionMiddleware, which might be nil. Returning an error rejects the
+	// request with 403. If nil, a stored (not new) session is required.
+	Authorize func(c echo.Context, sess *sessions.Session) error
+	// OnConnect is called after the upgrade, e.g. to join rooms. Returning an
+	// error closes the connection.
+	OnConnect func(conn *WSConn) error
+	// OnMessage is called for every message received. Returning an error
+	// closes the connection.
+	OnMessage func(conn *WSConn, msg []byte) error
+	// OnClose is called once the connection is closed.
+	OnClose func(conn *WSConn)
+}
+
+// Hub tracks the WebSocket connections of this replica and their rooms.
+// Messages broadcast to a room are fanned out to all replicas via Core.Bus.
+type Hub struct {
+	core     *Core
+	upgrader websocket.Upgrader
+	mu       sync.RWMutex
+	conns    map[*WSConn]struct{}
+	rooms    map[string]map[*WSConn]struct{}
+	// conns being served, counted by add under mu unless draining
+	wg       sync.WaitGroup
+	draining bool
+}
+
+// WSConn is a WebSocket connection managed by Hub.
+type WSConn struct {
+	ID      string
+	hub     *Hub
+	ws      *websocket.Conn
+	session *sessions.Session
+	send    chan []byte
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	done    chan struct{}
+}
+
+type wsEnvelope struct {
+	Room string `json:"room"`
+	Data []byte `json:"data"`
+}
+
+// InitHub creates Core.Hub. Connections are closed with a close frame once
+// the shutdown begins. Requires InitBus.
+func (c *Core) InitHub() InitHandler {
+	return func() error {
+		logInit("Hub")
+
+		if c.Bus == nil {
+			return errors.New("hub requires bus")
+		}
+
+		c.Hub = &Hub{
+			core: c,
+			upgrader: websocket.Upgrader{
+				ReadBufferSize:  c.Config.WS.BufferSize,
+				WriteBufferSize: c.Config.WS.BufferSize,
+			},
+			conns: map[*WSConn]struct{}{},
+			rooms: map[string]map[*WSConn]struct{}{},
+		}
+		Subscribe(c.Bus, c.Config.WS.Topic, c.Hub.deliver)
+		c.OnShutdown("Hub", PriorityWorkers, 0, c.Hub.wait)
+		return nil
+	}
+}
+
+// Handler returns the handler upgrading requests to WebSocket connections.
+func (h *Hub) Handler(cfg WSConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		sess, _ := c.Get(CtxSession).(*sessions.Session)
+		route := NewRoute(c)
+		if cfg.Authorize != nil {
+			if err := cfg.Authorize(c, sess); err != nil {
+				return route.Problem(http.StatusForbidden, err.Error())
+			}
+		} else if sess == nil || sess.IsNew {
+			return route.Problem(http.StatusUnauthorized, "session required")
+		}
+
+		if h.core.Context().Err() != nil {
+			return route.Problem(http.StatusServiceUnavailable, "shutting down")
+		}
+
+		ws, err := h.upgrader.Upgrade(c.Response(), c.Request(), nil)
+		if err != nil {
+			// the upgrader already responded
+			logrus.Debugf("[Hub] %s", err.Error())
+			return nil
+		}
+
+		conn := h.newConn(ws, sess)
+		if !h.add(conn) {
+			conn.writeClose(conn.ctx.Err(), time.Duration(h.core.Config.WS.WriteTimeout)*time.Second)
+			return ws.Close()
+		}
+		defer h.remove(conn)
+
+		go conn.writePump()
+		if cfg.OnConnect != nil {
+			if err = cfg.OnConnect(conn); err != nil {
+				conn.Close(err)
+			}
+		}
+		conn.readPump(cfg.OnMessage)
+		<-conn.done
+
+		if cfg.OnClose != nil {
+			cfg.OnClose(conn)
+		}
+		return nil
+	}
+}
+
+// Broadcast sends data to all connections in room on all replicas.
+func (h *Hub) Broadcast(ctx context.Context, room string, data []byte) error {
+	return h.core.Bus.Publish(ctx, h.core.Config.WS.Topic, &wsEnvelope{Room: room, Data: data})
+}
+
+// Conns returns the number of connections of this replica.
+func (h *Hub) Conns() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+func (h *Hub) newConn(ws *websocket.Conn, sess *sessions.Session) *WSConn {
+	bs := make([]byte, wsConnIDLen)
+	_, _ = rand.Read(bs)
+	conn := &WSConn{
+		ID:      hex.EncodeToString(bs),
+		hub:     h,
+		ws:      ws,
+		session: sess,
+		send:    make(chan []byte, h.core.Config.WS.SendBuffer),
+		done:    make(chan struct{}),
+	}
+	conn.ctx, conn.cancel = context.WithCancelCause(h.core.Context())
+	return conn
+}
+
+func (h *Hub) add(conn *WSConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.draining || h.core.Context().Err() != nil {
+		return false
+	}
+	h.wg.Add(1)
+	h.conns[conn] = struct{}{}
+	return true
+}
+
+func (h *Hub) remove(conn *WSConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, conn)
+	for room, conns := range h.rooms {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+	h.wg.Done()
+}
+
+func (h *Hub) deliver(_ context.Context, env wsEnvelope) error {
+	h.mu.RLock()
+	conns := make([]*WSConn, 0, len(h.rooms[env.Room]))
+	for conn := range h.rooms[env.Room] {
+		conns = append(conns, conn)
+	}
+	h.mu.RUnlock()
+
+	for _, conn := range conns {
+		_ = conn.Send(env.Data)
+	}
+	return nil
+}
+
+func (h *Hub) wait(ctx context.Context) error {
+	// once draining is set no add can increase the counter anymore, so
+	// Wait does not race with Add
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Session returns the session the connection was authenticated with.
+func (conn *WSConn) Session() *sessions.Session {
+	return conn.session
+}
+
+// Context is canceled once the connection is closed.
+func (conn *WSConn) Context() context.Context {
+	return conn.ctx
+}
+
+// Join adds the connection to room.
+func (conn *WSConn) Join(room string) {
+	h := conn.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[conn]; !ok {
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = map[*WSConn]struct{}{}
+	}
+	h.rooms[room][conn] = struct{}{}
+}
+
+// Leave removes the connection from room.
+func (conn *WSConn) Leave(room string) {
+	h := conn.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.rooms[room], conn)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Send queues a text message. If the send buffer (WS_SEND_BUFFER) is full,
+// the client is too slow and the connection gets closed.
+func (conn *WSConn) Send(data []byte) error {
+	if conn.ctx.Err() != nil {
+		return context.Cause(conn.ctx)
+	}
+	select {
+	case conn.send <- data:
+		return nil
+	default:
+		conn.Close(ErrWSSlowConsumer)
+		return ErrWSSlowConsumer
+	}
+}
+
+// Close sends a close frame and closes the connection. The cause is available
+// via context.Cause on the connection context.
+func (conn *WSConn) Close(cause error) {
+	conn.cancel(cause)
+}
+
+func (conn *WSConn) readPump(onMessage func(conn *WSConn, msg []byte) error) {
+	cfg := conn.hub.core.Config.WS
+	pongTimeout := time.Duration(cfg.PongTimeout) * time.Second
+
+	conn.ws.SetReadLimit(int64(cfg.MaxMessage))
+	_ = conn.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.ws.SetPongHandler(func(string) error {
+		return conn.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		_, msg, err := conn.ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && conn.ctx.Err() == nil {
+				logrus.Debugf("[Hub] [%s] %s", conn.ID, err.Error())
+			}
+			conn.cancel(err)
+			return
+		}
+		if onMessage == nil {
+			continue
+		}
+		if err = onMessage(conn, msg); err != nil {
+			conn.Close(err)
+			return
+		}
+	}
+}
+
+func (conn *WSConn) writePump() {
+	defer close(conn.done)
+	defer func() { _ = conn.ws.Close() }()
+
+	cfg := conn.hub.core.Config.WS
+	writeTimeout := time.Duration(cfg.WriteTimeout) * time.Second
+
+	ticker := time.NewTicker(time.Duration(cfg.PingInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			conn.writeClose(context.Cause(conn.ctx), writeTimeout)
+			return
+		case msg := <-conn.send:
+			_ = conn.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				conn.cancel(err)
+				return
+			}
+		case <-ticker.C:
+			if err := conn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				conn.cancel(err)
+				return
+			}
+		}
+	}
+}
+
+func (conn *WSConn) writeClose(cause error, timeout time.Duration) {
+	var closeErr *websocket.CloseError
+	if errors.As(cause, &closeErr) {
+		// closed by the client or the connection broke
+		return
+	}
+
+	code, text := websocket.CloseNormalClosure, ""
+	switch {
+	case errors.Is(cause, ErrWSSlowConsumer):
+		code, text = websocket.ClosePolicyViolation, cause.Error()
+	case conn.hub.core.Context().Err() != nil:
+		code, text = websocket.CloseGoingAway, "server shutdown"
+	case cause != nil && !errors.Is(cause, context.Canceled):
+		code = websocket.CloseInternalServerErr
+	}
+	msg := websocket.FormatCloseMessage(code, text)
+	_ = conn.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(timeout))
+}
@@ -0,0 +1,130 @@
+package echocore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	workerBackoffMin   = time.Second
+	workerBackoffMax   = time.Minute
+	workerBackoffReset = 5 * time.Minute
+)
+
+// WorkerFunc is a long-running background task. It must return once ctx is
+// done. Returning an error (or panicking) restarts the worker with backoff,
+// returning nil ends it for good.
+type WorkerFunc func(ctx context.Context) error
+
+type worker struct {
+	name string
+	fn   WorkerFunc
+}
+
+// Context returns a context which is canceled as soon as the shutdown begins.
+func (c *Core) Context() context.Context {
+	c.ctxOnce.Do(c.initContext)
+	return c.ctx
+}
+
+func (c *Core) initContext() {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+}
+
+func (c *Core) cancelContext() {
+	c.ctxOnce.Do(c.initContext)
+	c.cancel()
+}
+
+// Worker registers a background worker. Workers are started by Run together
+// with the HTTP server, or right away if they are already running. Workers
+// registered once the shutdown began are not started.
+func (c *Core) Worker(name string, fn WorkerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Context().Err() != nil {
+		logrus.Warnf("[Worker] [%s] Not started, shutting down", name)
+		return
+	}
+	w := worker{name: name, fn: fn}
+	if c.workersStarted {
+		c.startWorker(w)
+		return
+	}
+	c.workers = append(c.workers, w)
+}
+
+// StartWorkers starts all registered workers and registers a shutdown hook
+// waiting for them to return. It is called by Run.
+func (c *Core) StartWorkers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.workersStarted || c.Context().Err() != nil {
+		return
+	}
+	c.workersStarted = true
+	for _, w := range c.workers {
+		c.startWorker(w)
+	}
+	c.workers = nil
+	c.hooks = append(c.hooks, shutdownHook{name: "Workers", priority: PriorityWorkers, fn: c.waitWorkers})
+}
+
+func (c *Core) startWorker(w worker) {
+	logrus.Debugf("[Worker] [%s] Starting", w.name)
+	c.workerWg.Add(1)
+	go c.supervise(w)
+}
+
+func (c *Core) supervise(w worker) {
+	defer c.workerWg.Done()
+
+	ctx := c.Context()
+	backoff := workerBackoffMin
+
+	for {
+		start := time.Now()
+		err := runWorker(ctx, w.fn)
+		if ctx.Err() != nil {
+			logrus.Debugf("[Worker] [%s] Stopped", w.name)
+			return
+		}
+		if err == nil {
+			logrus.Debugf("[Worker] [%s] Finished", w.name)
+			return
+		}
+		if time.Since(start) > workerBackoffReset {
+			backoff = workerBackoffMin
+		}
+		logrus.Errorf("[Worker] [%s] %s, restarting in %s", w.name, err.Error(), backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, workerBackoffMax)
+	}
+}
+
+func runWorker(ctx context.Context, fn WorkerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err = fn(ctx); errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (c *Core) waitWorkers(ctx context.Context) error {
+	c.cancelContext()
+	if !waitGroup(ctx, &c.workerWg) {
+		return ctx.Err()
+	}
+	return nil
+}